@@ -526,12 +526,12 @@ func (dec *amf0Decoder) readSliceData(v reflect.Value) (err error) {
 }
 
 func (dec *amf0Decoder) readStrictArray() (v []interface{}, err error) {
-	err = dec.readSliceData(reflect.ValueOf(v))
+	err = dec.readSliceData(reflect.ValueOf(&v).Elem())
 	return
 }
 
 func (dec *amf0Decoder) readObject() (v map[string]interface{}, err error) {
-	err = dec.readMapData(reflect.ValueOf(v))
+	err = dec.readMapData(reflect.ValueOf(&v).Elem())
 	return
 }
 
@@ -550,7 +550,7 @@ func (dec *amf0Decoder) skipValue(m uint8) bool {
 	case amf0Reference:
 		return dec.next(2)
 	case amf0Array:
-		return dec.next(2) && dec.skipObject()
+		return dec.next(4) && dec.skipObject()
 	case amf0StrictArray:
 		return dec.skipStrictArray()
 	case amf0Date:
@@ -125,3 +125,20 @@ func TestPlainTypes(t *testing.T) {
 	ts, _ := time.Parse("02 Jan 06 15:04", "02 Jan 06 15:04")
 	assert(ts, "0b427088ba56b000000000", ts)
 }
+
+func TestDecodeObject(t *testing.T) {
+	enc := NewEncoder(0)
+	enc.Encode(map[string]interface{}{"a": "b", "c": []interface{}{float64(1)}})
+	enc.WriteString("tail")
+	dec := NewDecoder(0, enc.Bytes())
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		t.Fatal("decode:", err)
+	}
+	if m := v.(map[string]interface{}); m["a"] != "b" || !reflect.DeepEqual(m["c"], []interface{}{float64(1)}) {
+		t.Fatalf("decode: %v", v)
+	}
+	if s, err := dec.ReadString(); err != nil || s != "tail" {
+		t.Fatalf("decode: %q %v", s, err)
+	}
+}
@@ -0,0 +1,71 @@
+// Command flv2mp4 remuxes FLV files into progressive or fragmented MP4.
+//
+// Usage:
+//
+//	flv2mp4 [-frag] input.flv output.mp4
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/pixelbender/go-rtmp/flv"
+	"github.com/pixelbender/go-rtmp/mp4"
+)
+
+func main() {
+	frag := flag.Bool("frag", false, "write fragmented MP4 with a fragment per GOP")
+	flag.Parse()
+
+	if flag.NArg() != 2 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(flag.Arg(0), flag.Arg(1), *frag); err != nil {
+		fmt.Fprintln(os.Stderr, "flv2mp4:", err)
+		os.Exit(1)
+	}
+}
+
+func run(input, output string, frag bool) error {
+	in, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	var w *mp4.Writer
+	if frag {
+		w = mp4.NewFragmentWriter(out)
+	} else {
+		w = mp4.NewWriter(out)
+	}
+	r := flv.NewReader(in)
+	for {
+		tag, data, err := r.ReadTag()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		b, err := ioutil.ReadAll(data)
+		if err != nil {
+			return err
+		}
+		if err = w.WriteTag(tag, b); err != nil {
+			return err
+		}
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
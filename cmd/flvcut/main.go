@@ -0,0 +1,72 @@
+// Command flvcut trims, concatenates and rebases FLV files.
+//
+// Usage:
+//
+//	flvcut [-ss start] [-to end] [-o output] input
+//	flvcut -concat [-o output] input...
+//	flvcut -rebase [-o output] input
+//
+// The output is written to stdout unless -o is given.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/pixelbender/go-rtmp/flv"
+)
+
+func main() {
+	start := flag.Duration("ss", 0, "start `time` of the cut, the output begins at the next key frame")
+	end := flag.Duration("to", -1, "end `time` of the cut")
+	concat := flag.Bool("concat", false, "concatenate inputs")
+	rebase := flag.Bool("rebase", false, "shift timestamps to start at zero")
+	output := flag.String("o", "", "output `file`")
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) == 0 || !*concat && len(args) > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := run(args, *output, *concat, *rebase, *start, *end); err != nil {
+		fmt.Fprintln(os.Stderr, "flvcut:", err)
+		os.Exit(1)
+	}
+}
+
+func run(args []string, output string, concat, rebase bool, start, end time.Duration) error {
+	rs := make([]*flv.Reader, 0, len(args))
+	for _, name := range args {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		rs = append(rs, flv.NewReader(f))
+	}
+	var out io.Writer = os.Stdout
+	if output != "" {
+		f, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		out = f
+	}
+	w := flv.NewWriter(out)
+	switch {
+	case concat:
+		return flv.Concat(w, rs...)
+	case rebase:
+		return flv.Rebase(w, rs[0])
+	}
+	to := int64(-1)
+	if end >= 0 {
+		to = int64(end / time.Millisecond)
+	}
+	return flv.Cut(w, rs[0], int64(start/time.Millisecond), to)
+}
@@ -0,0 +1,207 @@
+// Package aac implements AudioSpecificConfig parsing and building
+// and conversion of raw AAC frames to and from ADTS.
+package aac
+
+import (
+	"errors"
+
+	"github.com/pixelbender/go-rtmp/codec/internal/bits"
+	"github.com/pixelbender/go-rtmp/flv"
+)
+
+// ErrFormat is returned when data is not a valid AAC syntax.
+var ErrFormat = errors.New("aac: incorrect format")
+
+const (
+	ObjectMain = uint8(1)
+	ObjectLC   = uint8(2)
+	ObjectSSR  = uint8(3)
+	ObjectLTP  = uint8(4)
+	ObjectSBR  = uint8(5)
+	ObjectPS   = uint8(29)
+)
+
+var sampleRates = [...]int{96000, 88200, 64000, 48000, 44100, 32000, 24000, 22050, 16000, 12000, 11025, 8000, 7350}
+
+// Config represents the AudioSpecificConfig.
+// ObjectType and SampleRate describe the core codec, SBR and PS signal HE-AAC extensions.
+type Config struct {
+	ObjectType    uint8
+	SampleRate    int
+	Channels      uint8
+	FrameLength   int
+	SBR           bool
+	PS            bool
+	ExtSampleRate int
+}
+
+// ChannelCount returns the number of channels for the channel configuration.
+func (c *Config) ChannelCount() int {
+	if c.Channels == 7 {
+		return 8
+	}
+	return int(c.Channels)
+}
+
+// OutputSampleRate returns the sample rate of decoded audio.
+func (c *Config) OutputSampleRate() int {
+	if c.SBR && c.ExtSampleRate > 0 {
+		return c.ExtSampleRate
+	}
+	return c.SampleRate
+}
+
+// ParseSequenceHeader parses the config from FLV audio tag data or RTMP audio message.
+func ParseSequenceHeader(data []byte) (*Config, error) {
+	h, n, err := flv.ParseAudioHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if !h.IsSequenceHeader() {
+		return nil, ErrFormat
+	}
+	return ParseConfig(data[n:])
+}
+
+// AppendSequenceHeader appends FLV audio tag data carrying the config to b.
+func AppendSequenceHeader(b []byte, c *Config) []byte {
+	return append(append(b, 0xaf, flv.AACSequenceHeader), c.Bytes()...)
+}
+
+// AppendFrame appends FLV audio tag data carrying the raw AAC frame to b.
+func AppendFrame(b []byte, frame []byte) []byte {
+	return append(append(b, 0xaf, flv.AACRaw), frame...)
+}
+
+// ParseConfig parses the AudioSpecificConfig.
+func ParseConfig(b []byte) (*Config, error) {
+	r := bits.NewReader(b)
+	c := &Config{FrameLength: 1024}
+	c.ObjectType = readObjectType(r)
+	c.SampleRate = readSampleRate(r)
+	c.Channels = uint8(r.ReadBits(4))
+	if c.ObjectType == ObjectSBR || c.ObjectType == ObjectPS {
+		c.SBR, c.PS = true, c.ObjectType == ObjectPS
+		c.ExtSampleRate = readSampleRate(r)
+		c.ObjectType = readObjectType(r)
+	}
+	switch c.ObjectType {
+	case ObjectMain, ObjectLC, ObjectSSR, ObjectLTP, 6, 7, 17, 19, 20, 21, 22, 23:
+		if r.ReadFlag() {
+			c.FrameLength = 960
+		}
+		if r.ReadFlag() {
+			r.Skip(14) // coreCoderDelay
+		}
+		ext := r.ReadFlag()
+		if c.Channels == 0 {
+			return nil, ErrFormat // program_config_element is not supported
+		}
+		if c.ObjectType == 6 || c.ObjectType == 20 {
+			r.Skip(3) // layerNr
+		}
+		if ext {
+			if c.ObjectType == 22 {
+				r.Skip(16) // numOfSubFrame, layer_length
+			}
+			switch c.ObjectType {
+			case 17, 19, 20, 23:
+				r.Skip(3) // resilience flags
+			}
+			r.Skip(1) // extensionFlag3
+		}
+	default:
+		if r.Err() != nil {
+			return nil, ErrFormat
+		}
+		return c, nil
+	}
+	if r.Err() != nil {
+		return nil, ErrFormat
+	}
+	if !c.SBR && r.Left() >= 16 && r.ReadBits(11) == 0x2b7 {
+		if readObjectType(r) == ObjectSBR && r.ReadFlag() {
+			c.SBR = true
+			c.ExtSampleRate = readSampleRate(r)
+			if r.Left() >= 12 && r.ReadBits(11) == 0x548 {
+				c.PS = r.ReadFlag()
+			}
+		}
+		if r.Err() != nil {
+			c.SBR, c.PS, c.ExtSampleRate = false, false, 0
+		}
+	}
+	return c, nil
+}
+
+// Bytes returns the binary representation of the AudioSpecificConfig.
+// HE-AAC extensions are signalled hierarchically.
+func (c *Config) Bytes() []byte {
+	w := &bits.Writer{}
+	switch {
+	case c.PS:
+		writeObjectType(w, ObjectPS)
+	case c.SBR:
+		writeObjectType(w, ObjectSBR)
+	default:
+		writeObjectType(w, c.ObjectType)
+	}
+	writeSampleRate(w, c.SampleRate)
+	w.WriteBits(uint32(c.Channels), 4)
+	if c.SBR || c.PS {
+		rate := c.ExtSampleRate
+		if rate == 0 {
+			rate = c.SampleRate << 1
+		}
+		writeSampleRate(w, rate)
+		writeObjectType(w, c.ObjectType)
+	}
+	w.WriteFlag(c.FrameLength == 960)
+	w.WriteBits(0, 2) // dependsOnCoreCoder, extensionFlag
+	return w.Bytes()
+}
+
+func readObjectType(r *bits.Reader) uint8 {
+	if v := uint8(r.ReadBits(5)); v != 31 {
+		return v
+	}
+	return uint8(32 + r.ReadBits(6))
+}
+
+func writeObjectType(w *bits.Writer, v uint8) {
+	if v < 31 {
+		w.WriteBits(uint32(v), 5)
+	} else {
+		w.WriteBits(31, 5)
+		w.WriteBits(uint32(v-32), 6)
+	}
+}
+
+func readSampleRate(r *bits.Reader) int {
+	i := r.ReadBits(4)
+	if i == 0xf {
+		return int(r.ReadBits(24))
+	}
+	if int(i) < len(sampleRates) {
+		return sampleRates[i]
+	}
+	return 0
+}
+
+func writeSampleRate(w *bits.Writer, v int) {
+	if i := sampleRateIndex(v); i >= 0 {
+		w.WriteBits(uint32(i), 4)
+	} else {
+		w.WriteBits(0xf, 4)
+		w.WriteBits(uint32(v), 24)
+	}
+}
+
+func sampleRateIndex(v int) int {
+	for i, it := range sampleRates {
+		if it == v {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,78 @@
+package aac
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestConfig(t *testing.T) {
+	tests := []struct {
+		data   []byte
+		config *Config
+	}{
+		{[]byte{0x12, 0x10}, &Config{ObjectType: ObjectLC, SampleRate: 44100, Channels: 2, FrameLength: 1024}},
+		{[]byte{0x11, 0x88}, &Config{ObjectType: ObjectLC, SampleRate: 48000, Channels: 1, FrameLength: 1024}},
+		{[]byte{0x2b, 0x92, 0x08, 0x00}, &Config{ObjectType: ObjectLC, SampleRate: 22050, Channels: 2, FrameLength: 1024, SBR: true, ExtSampleRate: 44100}},
+		{[]byte{0xeb, 0x09, 0x88, 0x00}, &Config{ObjectType: ObjectLC, SampleRate: 24000, Channels: 1, FrameLength: 1024, SBR: true, PS: true, ExtSampleRate: 48000}},
+		{[]byte{0x17, 0x80, 0x01, 0xf4, 0x10}, &Config{ObjectType: ObjectLC, SampleRate: 1000, Channels: 2, FrameLength: 1024}},
+	}
+	for _, it := range tests {
+		c, err := ParseSequenceHeader(AppendSequenceHeader(nil, it.config))
+		if err != nil {
+			t.Fatal("parse config:", err)
+		}
+		if !reflect.DeepEqual(c, it.config) {
+			t.Fatalf("parse config: %+v != %+v", c, it.config)
+		}
+		if b := c.Bytes(); !bytes.Equal(b, it.data) {
+			t.Fatalf("config bytes: %x != %x", b, it.data)
+		}
+	}
+}
+
+func TestImplicitSBR(t *testing.T) {
+	c, err := ParseConfig([]byte{0x13, 0x10, 0x56, 0xe5, 0x98})
+	if err != nil {
+		t.Fatal("parse config:", err)
+	}
+	if !c.SBR || c.SampleRate != 24000 || c.OutputSampleRate() != 48000 {
+		t.Fatalf("parse config: %+v", c)
+	}
+}
+
+func TestResilienceFlags(t *testing.T) {
+	// ER TwinVQ config with extensionFlag has no resilience flags before the explicit SBR signaling
+	c, err := ParseConfig([]byte{0xaa, 0x11, 0x2b, 0x72, 0xcc})
+	if err != nil {
+		t.Fatal("parse config:", err)
+	}
+	if c.ObjectType != 21 || c.SampleRate != 44100 || !c.SBR || c.ExtSampleRate != 48000 {
+		t.Fatalf("parse config: %+v", c)
+	}
+}
+
+func TestADTS(t *testing.T) {
+	c := &Config{ObjectType: ObjectLC, SampleRate: 44100, Channels: 2, FrameLength: 1024}
+	frames := [][]byte{{0x21, 0x10, 0x04}, {0xde, 0x04, 0x00, 0x00}}
+	b := &bytes.Buffer{}
+	w := NewADTSWriter(b, c)
+	for _, it := range frames {
+		if err := w.WriteFrame(it); err != nil {
+			t.Fatal("write frame:", err)
+		}
+	}
+	if b.Bytes()[0] != 0xff || b.Bytes()[1] != 0xf1 || b.Bytes()[2] != 0x50 || b.Bytes()[3] != 0x80 {
+		t.Fatalf("adts header: %x", b.Bytes()[:7])
+	}
+	r := NewADTSReader(b)
+	for _, it := range frames {
+		rc, f, err := r.ReadFrame()
+		if err != nil {
+			t.Fatal("read frame:", err)
+		}
+		if !reflect.DeepEqual(rc, c) || !bytes.Equal(f, it) {
+			t.Fatalf("read frame: %+v %x != %+v %x", rc, f, c, it)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package aac
+
+import (
+	"bufio"
+	"io"
+)
+
+// ADTSHeaderSize is the size of ADTS header without CRC.
+const ADTSHeaderSize = 7
+
+// ParseADTS parses the ADTS header and returns the config, header size and frame size including header.
+func ParseADTS(b []byte) (c *Config, n int, size int, err error) {
+	if len(b) < ADTSHeaderSize || b[0] != 0xff || b[1]&0xf6 != 0xf0 {
+		return nil, 0, 0, ErrFormat
+	}
+	i := int(b[2] >> 2 & 0x0f)
+	if i >= len(sampleRates) {
+		return nil, 0, 0, ErrFormat
+	}
+	c = &Config{
+		ObjectType:  b[2]>>6 + 1,
+		SampleRate:  sampleRates[i],
+		Channels:    b[2]&0x01<<2 | b[3]>>6,
+		FrameLength: 1024,
+	}
+	n = ADTSHeaderSize
+	if b[1]&0x01 == 0 {
+		n += 2
+	}
+	size = int(b[3]&0x03)<<11 | int(b[4])<<3 | int(b[5]>>5)
+	if size < n {
+		return nil, 0, 0, ErrFormat
+	}
+	return
+}
+
+// AppendADTS appends the raw AAC frame with ADTS header to b.
+// Only object types up to LTP with standard sample rates can be represented.
+func AppendADTS(b []byte, c *Config, frame []byte) ([]byte, error) {
+	i := sampleRateIndex(c.SampleRate)
+	if i < 0 || c.ObjectType < ObjectMain || c.ObjectType > ObjectLTP || c.Channels > 7 {
+		return nil, ErrFormat
+	}
+	size := ADTSHeaderSize + len(frame)
+	if size > 0x1fff {
+		return nil, ErrFormat
+	}
+	b = append(b,
+		0xff,
+		0xf1,
+		(c.ObjectType-1)<<6|uint8(i)<<2|c.Channels>>2,
+		c.Channels<<6|uint8(size>>11),
+		uint8(size>>3),
+		uint8(size<<5)|0x1f,
+		0xfc,
+	)
+	return append(b, frame...), nil
+}
+
+// ADTSReader reads raw AAC frames from an ADTS stream.
+type ADTSReader struct {
+	buf *bufio.Reader
+}
+
+// NewADTSReader returns a new reader that reads from r.
+func NewADTSReader(r io.Reader) *ADTSReader {
+	buf, _ := r.(*bufio.Reader)
+	if buf == nil {
+		buf = bufio.NewReader(r)
+	}
+	return &ADTSReader{buf}
+}
+
+// ReadFrame reads the next frame and returns its config and raw data.
+func (r *ADTSReader) ReadFrame() (c *Config, frame []byte, err error) {
+	var b []byte
+	if b, err = r.buf.Peek(ADTSHeaderSize); err != nil {
+		if err == io.EOF && len(b) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	var n, size int
+	if c, n, size, err = ParseADTS(b); err != nil {
+		return
+	}
+	b = make([]byte, size)
+	if _, err = io.ReadFull(r.buf, b); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, nil, err
+	}
+	return c, b[n:], nil
+}
+
+// ADTSWriter writes raw AAC frames as an ADTS stream.
+type ADTSWriter struct {
+	w      io.Writer
+	config *Config
+	buf    []byte
+}
+
+// NewADTSWriter returns a new writer of frames with the given config.
+func NewADTSWriter(w io.Writer, c *Config) *ADTSWriter {
+	return &ADTSWriter{w: w, config: c}
+}
+
+// WriteFrame writes the raw AAC frame with ADTS header.
+func (w *ADTSWriter) WriteFrame(frame []byte) (err error) {
+	if w.buf, err = AppendADTS(w.buf[:0], w.config, frame); err != nil {
+		return
+	}
+	_, err = w.w.Write(w.buf)
+	return
+}
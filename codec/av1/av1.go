@@ -0,0 +1,177 @@
+// Package av1 implements parsing of AV1 codec configuration records and sequence header OBUs.
+package av1
+
+import (
+	"errors"
+
+	"github.com/pixelbender/go-rtmp/codec/internal/bits"
+	"github.com/pixelbender/go-rtmp/flv"
+)
+
+// ErrFormat is returned when data is not a valid AV1 syntax.
+var ErrFormat = errors.New("av1: incorrect format")
+
+const (
+	OBUSequenceHeader       = uint8(1)
+	OBUTemporalDelimiter    = uint8(2)
+	OBUFrameHeader          = uint8(3)
+	OBUTileGroup            = uint8(4)
+	OBUMetadata             = uint8(5)
+	OBUFrame                = uint8(6)
+	OBURedundantFrameHeader = uint8(7)
+	OBUTileList             = uint8(8)
+	OBUPadding              = uint8(15)
+)
+
+// CodecConfig represents the AV1CodecConfigurationRecord.
+type CodecConfig struct {
+	Version              uint8
+	Profile              uint8
+	Level                uint8
+	Tier                 uint8
+	HighBitDepth         bool
+	TwelveBit            bool
+	Monochrome           bool
+	SubsamplingX         bool
+	SubsamplingY         bool
+	ChromaSamplePosition uint8
+	PresentationDelay    int
+	ConfigOBUs           []byte
+}
+
+// ParseSequenceHeader parses the codec configuration from Enhanced FLV video tag data or RTMP video message.
+func ParseSequenceHeader(data []byte) (*CodecConfig, error) {
+	h, n, err := flv.ParseVideoHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if h.FourCC != flv.FourCCAV1 || !h.IsSequenceHeader() {
+		return nil, ErrFormat
+	}
+	return ParseCodecConfig(data[n:])
+}
+
+// ParseCodecConfig parses the AV1CodecConfigurationRecord.
+func ParseCodecConfig(b []byte) (*CodecConfig, error) {
+	if len(b) < 4 || b[0]&0x80 == 0 {
+		return nil, ErrFormat
+	}
+	c := &CodecConfig{
+		Version:              b[0] & 0x7f,
+		Profile:              b[1] >> 5,
+		Level:                b[1] & 0x1f,
+		Tier:                 b[2] >> 7,
+		HighBitDepth:         b[2]&0x40 != 0,
+		TwelveBit:            b[2]&0x20 != 0,
+		Monochrome:           b[2]&0x10 != 0,
+		SubsamplingX:         b[2]&0x08 != 0,
+		SubsamplingY:         b[2]&0x04 != 0,
+		ChromaSamplePosition: b[2] & 0x03,
+		ConfigOBUs:           b[4:],
+	}
+	if b[3]&0x10 != 0 {
+		c.PresentationDelay = int(b[3]&0x0f) + 1
+	}
+	return c, nil
+}
+
+// Bytes returns the binary representation of the AV1CodecConfigurationRecord.
+func (c *CodecConfig) Bytes() []byte {
+	b := []byte{0x81, c.Profile<<5 | c.Level&0x1f, c.Tier<<7 | c.ChromaSamplePosition&0x03, 0}
+	for i, it := range []bool{c.HighBitDepth, c.TwelveBit, c.Monochrome, c.SubsamplingX, c.SubsamplingY} {
+		if it {
+			b[2] |= 0x40 >> uint(i)
+		}
+	}
+	if c.PresentationDelay > 0 {
+		b[3] = 0x10 | uint8(c.PresentationDelay-1)&0x0f
+	}
+	return append(b, c.ConfigOBUs...)
+}
+
+// BitDepth returns the bit depth of samples.
+func (c *CodecConfig) BitDepth() int {
+	return bitDepth(c.HighBitDepth, c.TwelveBit)
+}
+
+// ChromaFormat returns the chroma format in the H.264/HEVC chroma_format_idc notation.
+func (c *CodecConfig) ChromaFormat() uint8 {
+	return chromaFormat(c.Monochrome, c.SubsamplingX, c.SubsamplingY)
+}
+
+// SequenceHeader finds and parses the sequence header OBU of the configuration.
+func (c *CodecConfig) SequenceHeader() (*SequenceHeader, error) {
+	b := c.ConfigOBUs
+	for len(b) > 0 {
+		h, payload, tail, err := SplitOBU(b)
+		if err != nil {
+			return nil, err
+		}
+		if h.Type == OBUSequenceHeader {
+			return ParseSequenceHeaderOBU(payload)
+		}
+		b = tail
+	}
+	return nil, ErrFormat
+}
+
+// OBUHeader represents the OBU header.
+type OBUHeader struct {
+	Type       uint8
+	HasSize    bool
+	TemporalID uint8
+	SpatialID  uint8
+}
+
+// SplitOBU reads the OBU header and returns its payload and the remaining data.
+// The OBU without size field extends to the end of data.
+func SplitOBU(b []byte) (h *OBUHeader, payload, tail []byte, err error) {
+	if len(b) < 1 || b[0]&0x80 != 0 {
+		return nil, nil, nil, ErrFormat
+	}
+	h = &OBUHeader{
+		Type:    b[0] >> 3 & 0x0f,
+		HasSize: b[0]&0x02 != 0,
+	}
+	n := 1
+	if b[0]&0x04 != 0 {
+		if len(b) < 2 {
+			return nil, nil, nil, ErrFormat
+		}
+		h.TemporalID, h.SpatialID = b[1]>>5, b[1]>>3&0x03
+		n++
+	}
+	if !h.HasSize {
+		return h, b[n:], nil, nil
+	}
+	r := bits.NewReader(b[n:])
+	size := r.ReadLEB128()
+	n += (len(b[n:])<<3 - r.Left()) >> 3
+	if r.Err() != nil || uint64(len(b)-n) < size {
+		return nil, nil, nil, ErrFormat
+	}
+	end := n + int(size)
+	return h, b[n:end:end], b[end:], nil
+}
+
+func bitDepth(high, twelve bool) int {
+	switch {
+	case twelve:
+		return 12
+	case high:
+		return 10
+	}
+	return 8
+}
+
+func chromaFormat(mono, x, y bool) uint8 {
+	switch {
+	case mono:
+		return 0
+	case x && y:
+		return 1
+	case x:
+		return 2
+	}
+	return 3
+}
@@ -0,0 +1,36 @@
+package av1
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func TestCodecConfig(t *testing.T) {
+	b, _ := hex.DecodeString("81080c000a0b00000042abbfc373ffe601")
+	data := append([]byte{0x90, 'a', 'v', '0', '1'}, b...)
+	c, err := ParseSequenceHeader(data)
+	if err != nil {
+		t.Fatal("parse config:", err)
+	}
+	if c.Profile != 0 || c.Level != 8 || c.BitDepth() != 8 || c.ChromaFormat() != 1 {
+		t.Fatalf("parse config: %+v", c)
+	}
+	if r := c.Bytes(); !reflect.DeepEqual(r, b) {
+		t.Fatalf("config bytes: %x != %x", r, b)
+	}
+	s, err := c.SequenceHeader()
+	if err != nil {
+		t.Fatal("parse sequence header:", err)
+	}
+	if s.MaxWidth != 1920 || s.MaxHeight != 1080 || s.Level != 8 || s.BitDepth != 8 || s.ChromaFormat() != 1 {
+		t.Fatalf("parse sequence header: %+v", s)
+	}
+}
+
+func TestFrameRate(t *testing.T) {
+	s := &SequenceHeader{TimingInfo: true, EqualPictureInterval: true, TimeScale: 1 << 31, NumUnitsInTick: 1 << 16, TicksPerPicture: 1 << 16}
+	if r := s.FrameRate(); r != 0.5 {
+		t.Fatalf("frame rate: %v", r)
+	}
+}
@@ -0,0 +1,162 @@
+package av1
+
+import (
+	"github.com/pixelbender/go-rtmp/codec/internal/bits"
+)
+
+// SequenceHeader represents the decoded sequence header OBU.
+type SequenceHeader struct {
+	Profile                 uint8
+	StillPicture            bool
+	ReducedHeader           bool
+	Level                   uint8
+	Tier                    uint8
+	MaxWidth                int
+	MaxHeight               int
+	BitDepth                int
+	Monochrome              bool
+	SubsamplingX            bool
+	SubsamplingY            bool
+	ColorPrimaries          uint8
+	TransferCharacteristics uint8
+	MatrixCoefficients      uint8
+	FullRange               bool
+
+	TimingInfo           bool
+	NumUnitsInTick       uint32
+	TimeScale            uint32
+	EqualPictureInterval bool
+	TicksPerPicture      uint32
+}
+
+// ChromaFormat returns the chroma format in the H.264/HEVC chroma_format_idc notation.
+func (s *SequenceHeader) ChromaFormat() uint8 {
+	return chromaFormat(s.Monochrome, s.SubsamplingX, s.SubsamplingY)
+}
+
+// FrameRate returns the frame rate defined by timing info or zero if unknown.
+func (s *SequenceHeader) FrameRate() float64 {
+	if s.TimingInfo && s.EqualPictureInterval && s.NumUnitsInTick > 0 {
+		return float64(s.TimeScale) / float64(uint64(s.NumUnitsInTick)*uint64(s.TicksPerPicture))
+	}
+	return 0
+}
+
+// ParseSequenceHeaderOBU parses the sequence header OBU payload.
+func ParseSequenceHeaderOBU(b []byte) (*SequenceHeader, error) {
+	r := bits.NewReader(b)
+	s := &SequenceHeader{
+		Profile:       uint8(r.ReadBits(3)),
+		StillPicture:  r.ReadFlag(),
+		ReducedHeader: r.ReadFlag(),
+	}
+	decoderModel, delayLength := false, 0
+	if s.ReducedHeader {
+		s.Level = uint8(r.ReadBits(5))
+	} else {
+		if s.TimingInfo = r.ReadFlag(); s.TimingInfo {
+			s.NumUnitsInTick = r.ReadBits(32)
+			s.TimeScale = r.ReadBits(32)
+			if s.EqualPictureInterval = r.ReadFlag(); s.EqualPictureInterval {
+				s.TicksPerPicture = r.ReadUE() + 1
+			}
+			if decoderModel = r.ReadFlag(); decoderModel {
+				delayLength = int(r.ReadBits(5)) + 1
+				r.Skip(32 + 5 + 5)
+			}
+		}
+		initialDelay := r.ReadFlag()
+		n := int(r.ReadBits(5)) + 1
+		for i := 0; i < n; i++ {
+			r.Skip(12) // operating_point_idc
+			level, tier := uint8(r.ReadBits(5)), uint8(0)
+			if level > 7 {
+				tier = uint8(r.ReadBits(1))
+			}
+			if i == 0 {
+				s.Level, s.Tier = level, tier
+			}
+			if decoderModel && r.ReadFlag() {
+				r.Skip(2*delayLength + 1)
+			}
+			if initialDelay && r.ReadFlag() {
+				r.Skip(4)
+			}
+		}
+	}
+	wbits := int(r.ReadBits(4)) + 1
+	hbits := int(r.ReadBits(4)) + 1
+	s.MaxWidth = int(r.ReadBits(wbits)) + 1
+	s.MaxHeight = int(r.ReadBits(hbits)) + 1
+	if !s.ReducedHeader && r.ReadFlag() {
+		r.Skip(4 + 3) // delta_frame_id_length_minus_2, additional_frame_id_length_minus_1
+	}
+	r.Skip(3) // use_128x128_superblock, enable_filter_intra, enable_intra_edge_filter
+	if !s.ReducedHeader {
+		r.Skip(4) // enable_interintra_compound, enable_masked_compound, enable_warped_motion, enable_dual_filter
+		orderHint := r.ReadFlag()
+		if orderHint {
+			r.Skip(2) // enable_jnt_comp, enable_ref_frame_mvs
+		}
+		screenContent := uint32(2)
+		if !r.ReadFlag() {
+			screenContent = r.ReadBits(1)
+		}
+		if screenContent > 0 && !r.ReadFlag() {
+			r.Skip(1) // seq_force_integer_mv
+		}
+		if orderHint {
+			r.Skip(3)
+		}
+	}
+	r.Skip(3) // enable_superres, enable_cdef, enable_restoration
+	s.parseColorConfig(r)
+	if r.Err() != nil {
+		return nil, ErrFormat
+	}
+	return s, nil
+}
+
+func (s *SequenceHeader) parseColorConfig(r *bits.Reader) {
+	high, twelve := r.ReadFlag(), false
+	if s.Profile == 2 && high {
+		twelve = r.ReadFlag()
+	}
+	s.BitDepth = bitDepth(high, twelve)
+	if s.Profile != 1 {
+		s.Monochrome = r.ReadFlag()
+	}
+	s.ColorPrimaries, s.TransferCharacteristics, s.MatrixCoefficients = 2, 2, 2
+	if r.ReadFlag() {
+		s.ColorPrimaries = uint8(r.ReadBits(8))
+		s.TransferCharacteristics = uint8(r.ReadBits(8))
+		s.MatrixCoefficients = uint8(r.ReadBits(8))
+	}
+	switch {
+	case s.Monochrome:
+		s.FullRange = r.ReadFlag()
+		s.SubsamplingX, s.SubsamplingY = true, true
+		return
+	case s.ColorPrimaries == 1 && s.TransferCharacteristics == 13 && s.MatrixCoefficients == 0:
+		s.FullRange = true
+	default:
+		s.FullRange = r.ReadFlag()
+		switch s.Profile {
+		case 0:
+			s.SubsamplingX, s.SubsamplingY = true, true
+		case 1:
+		default:
+			if s.BitDepth == 12 {
+				if s.SubsamplingX = r.ReadFlag(); s.SubsamplingX {
+					s.SubsamplingY = r.ReadFlag()
+				}
+			} else {
+				s.SubsamplingX = true
+			}
+		}
+		if s.SubsamplingX && s.SubsamplingY {
+			r.Skip(2) // chroma_sample_position
+		}
+	}
+	r.Skip(1) // separate_uv_delta_q
+}
@@ -0,0 +1,165 @@
+// Package h264 implements parsing of H.264 decoder configuration records and parameter sets
+// and conversion between AVCC and Annex B NAL unit formats.
+package h264
+
+import (
+	"errors"
+
+	"github.com/pixelbender/go-rtmp/flv"
+)
+
+// ErrFormat is returned when data is not a valid H.264 syntax.
+var ErrFormat = errors.New("h264: incorrect format")
+
+const (
+	NALSlice    = uint8(1)
+	NALIDR      = uint8(5)
+	NALSEI      = uint8(6)
+	NALSPS      = uint8(7)
+	NALPPS      = uint8(8)
+	NALAUD      = uint8(9)
+	NALEndSeq   = uint8(10)
+	NALEndStr   = uint8(11)
+	NALFiller   = uint8(12)
+	NALSPSExt   = uint8(13)
+	NALTypeMask = uint8(0x1f)
+)
+
+// NALType returns the type of NAL unit.
+func NALType(nal []byte) uint8 {
+	if len(nal) == 0 {
+		return 0
+	}
+	return nal[0] & NALTypeMask
+}
+
+// DecoderConfig represents the AVCDecoderConfigurationRecord.
+type DecoderConfig struct {
+	Version       uint8
+	Profile       uint8
+	Compatibility uint8
+	Level         uint8
+	LengthSize    int
+	SPS           [][]byte
+	PPS           [][]byte
+
+	// High profile extension
+	ChromaFormat   uint8
+	BitDepthLuma   uint8
+	BitDepthChroma uint8
+	SPSExt         [][]byte
+}
+
+// ParseSequenceHeader parses the decoder configuration from FLV video tag data or RTMP video message.
+func ParseSequenceHeader(data []byte) (*DecoderConfig, error) {
+	h, n, err := flv.ParseVideoHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if !h.IsSequenceHeader() {
+		return nil, ErrFormat
+	}
+	return ParseDecoderConfig(data[n:])
+}
+
+// ParseDecoderConfig parses the AVCDecoderConfigurationRecord.
+func ParseDecoderConfig(b []byte) (c *DecoderConfig, err error) {
+	if len(b) < 7 || b[0] != 1 {
+		return nil, ErrFormat
+	}
+	c = &DecoderConfig{
+		Version:       b[0],
+		Profile:       b[1],
+		Compatibility: b[2],
+		Level:         b[3],
+		LengthSize:    int(b[4]&0x03) + 1,
+	}
+	if c.SPS, b, err = readParamSets(b[5:], 0x1f); err != nil {
+		return nil, err
+	}
+	if c.PPS, b, err = readParamSets(b, 0xff); err != nil {
+		return nil, err
+	}
+	if len(b) >= 4 && hasChromaInfo(c.Profile) {
+		c.ChromaFormat = b[0] & 0x03
+		c.BitDepthLuma = b[1]&0x07 + 8
+		c.BitDepthChroma = b[2]&0x07 + 8
+		if c.SPSExt, _, err = readParamSets(b[3:], 0xff); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// Bytes returns the binary representation of the AVCDecoderConfigurationRecord.
+func (c *DecoderConfig) Bytes() []byte {
+	b := []byte{1, c.Profile, c.Compatibility, c.Level, 0xfc | uint8(c.LengthSize-1)&0x03, 0xe0 | uint8(len(c.SPS))&0x1f}
+	b = appendParamSets(b, c.SPS)
+	b = append(b, uint8(len(c.PPS)))
+	b = appendParamSets(b, c.PPS)
+	if hasChromaInfo(c.Profile) && c.ChromaFormat != 0 {
+		b = append(b, 0xfc|c.ChromaFormat, 0xf8|(c.BitDepthLuma-8)&0x07, 0xf8|(c.BitDepthChroma-8)&0x07, uint8(len(c.SPSExt)))
+		b = appendParamSets(b, c.SPSExt)
+	}
+	return b
+}
+
+// NewDecoderConfig returns a decoder configuration for the given parameter sets.
+func NewDecoderConfig(sps, pps [][]byte) (*DecoderConfig, error) {
+	if len(sps) == 0 || len(pps) == 0 {
+		return nil, ErrFormat
+	}
+	s, err := ParseSPS(sps[0])
+	if err != nil {
+		return nil, err
+	}
+	c := &DecoderConfig{
+		Version:       1,
+		Profile:       s.Profile,
+		Compatibility: s.Constraints,
+		Level:         s.Level,
+		LengthSize:    4,
+		SPS:           sps,
+		PPS:           pps,
+	}
+	if hasChromaInfo(s.Profile) {
+		c.ChromaFormat = s.ChromaFormat
+		c.BitDepthLuma = s.BitDepthLuma
+		c.BitDepthChroma = s.BitDepthChroma
+	}
+	return c, nil
+}
+
+func hasChromaInfo(profile uint8) bool {
+	switch profile {
+	case 100, 110, 122, 144:
+		return true
+	}
+	return false
+}
+
+func readParamSets(b []byte, mask uint8) (r [][]byte, tail []byte, err error) {
+	if len(b) < 1 {
+		return nil, nil, ErrFormat
+	}
+	n := int(b[0] & mask)
+	for b = b[1:]; n > 0; n-- {
+		if len(b) < 2 {
+			return nil, nil, ErrFormat
+		}
+		size := int(b[0])<<8 | int(b[1])
+		if len(b) < 2+size {
+			return nil, nil, ErrFormat
+		}
+		r, b = append(r, b[2:2+size:2+size]), b[2+size:]
+	}
+	return r, b, nil
+}
+
+func appendParamSets(b []byte, sets [][]byte) []byte {
+	for _, it := range sets {
+		b = append(b, uint8(len(it)>>8), uint8(len(it)))
+		b = append(b, it...)
+	}
+	return b
+}
@@ -0,0 +1,70 @@
+package h264
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func decodeHex(t *testing.T, h string) []byte {
+	b, err := hex.DecodeString(h)
+	if err != nil {
+		t.Fatal("hex:", h)
+	}
+	return b
+}
+
+func TestParseSPS(t *testing.T) {
+	tests := []struct {
+		sps    string
+		width  int
+		height int
+		rate   float64
+	}{
+		{"6764002aacd940780227e5c044000003000400000300f03c60c658", 1920, 1080, 30},
+		{"6742c01fda014016ec0440000003004000000c23c60c92", 1280, 720, 24},
+	}
+	for _, it := range tests {
+		s, err := ParseSPS(decodeHex(t, it.sps))
+		if err != nil {
+			t.Fatal("parse sps:", err)
+		}
+		if s.Width != it.width || s.Height != it.height || s.FrameRate() != it.rate {
+			t.Fatalf("parse sps: %dx%d@%v != %dx%d@%v", s.Width, s.Height, s.FrameRate(), it.width, it.height, it.rate)
+		}
+	}
+}
+
+func TestDecoderConfig(t *testing.T) {
+	sps := decodeHex(t, "6764002aacd940780227e5c044000003000400000300f03c60c658")
+	pps := decodeHex(t, "68ebe3cb22c0")
+	c, err := NewDecoderConfig([][]byte{sps}, [][]byte{pps})
+	if err != nil {
+		t.Fatal("new config:", err)
+	}
+	r, err := ParseSequenceHeader(append([]byte{0x17, 0, 0, 0, 0}, c.Bytes()...))
+	if err != nil {
+		t.Fatal("parse config:", err)
+	}
+	if !reflect.DeepEqual(c, r) {
+		t.Fatalf("parse config: %+v != %+v", r, c)
+	}
+	if r.Profile != 100 || r.Level != 42 || r.ChromaFormat != 1 || r.BitDepthLuma != 8 {
+		t.Fatalf("parse config: %+v", r)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	avcc := decodeHex(t, "0000000209f00000000365888000000002068a")
+	annexb, err := ToAnnexB(avcc, 4)
+	if err != nil {
+		t.Fatal("to annexb:", err)
+	}
+	if exp := decodeHex(t, "0000000109f00000000165888000000001068a"); !bytes.Equal(annexb, exp) {
+		t.Fatalf("to annexb: %x != %x", annexb, exp)
+	}
+	if r := ToAVCC(append([]byte{0, 0, 1}, annexb[4:]...)); !bytes.Equal(r, avcc) {
+		t.Fatalf("to avcc: %x != %x", r, avcc)
+	}
+}
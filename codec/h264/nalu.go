@@ -0,0 +1,90 @@
+package h264
+
+var startCode = []byte{0, 0, 0, 1}
+
+// SplitAVCC splits length-prefixed NAL units.
+func SplitAVCC(b []byte, lengthSize int) (nals [][]byte, err error) {
+	if lengthSize < 1 || lengthSize > 4 {
+		return nil, ErrFormat
+	}
+	for len(b) > 0 {
+		if len(b) < lengthSize {
+			return nil, ErrFormat
+		}
+		n := 0
+		for _, c := range b[:lengthSize] {
+			n = n<<8 | int(c)
+		}
+		if b = b[lengthSize:]; n > len(b) {
+			return nil, ErrFormat
+		}
+		nals, b = append(nals, b[:n:n]), b[n:]
+	}
+	return
+}
+
+// SplitAnnexB splits NAL units separated by start codes.
+func SplitAnnexB(b []byte) (nals [][]byte) {
+	start, zeros := -1, 0
+	for i, c := range b {
+		switch {
+		case c == 0:
+			zeros++
+			continue
+		case c == 1 && zeros >= 2:
+			if start >= 0 {
+				nals = appendNAL(nals, b[start:i-zeros])
+			}
+			start = i + 1
+		}
+		zeros = 0
+	}
+	if start >= 0 {
+		nals = appendNAL(nals, b[start:])
+	}
+	return
+}
+
+func appendNAL(nals [][]byte, nal []byte) [][]byte {
+	for len(nal) > 0 && nal[len(nal)-1] == 0 {
+		nal = nal[:len(nal)-1]
+	}
+	if len(nal) == 0 {
+		return nals
+	}
+	return append(nals, nal[:len(nal):len(nal)])
+}
+
+// AppendAnnexB appends NAL units with start codes to b.
+func AppendAnnexB(b []byte, nals ...[]byte) []byte {
+	for _, it := range nals {
+		b = append(b, startCode...)
+		b = append(b, it...)
+	}
+	return b
+}
+
+// AppendAVCC appends NAL units with 4-byte length prefixes to b.
+func AppendAVCC(b []byte, nals ...[]byte) []byte {
+	for _, it := range nals {
+		n := len(it)
+		b = append(b, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+		b = append(b, it...)
+	}
+	return b
+}
+
+// ToAnnexB converts length-prefixed NAL units to Annex B byte stream format.
+func ToAnnexB(b []byte, lengthSize int) ([]byte, error) {
+	nals, err := SplitAVCC(b, lengthSize)
+	if err != nil {
+		return nil, err
+	}
+	return AppendAnnexB(make([]byte, 0, len(b)+len(nals)*4), nals...), nil
+}
+
+// ToAVCC converts Annex B byte stream to NAL units with 4-byte length prefixes.
+func ToAVCC(b []byte) []byte {
+	nals := SplitAnnexB(b)
+	return AppendAVCC(make([]byte, 0, len(b)+len(nals)*4), nals...)
+}
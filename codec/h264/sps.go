@@ -0,0 +1,204 @@
+package h264
+
+import (
+	"github.com/pixelbender/go-rtmp/codec/internal/bits"
+)
+
+// SPS represents the decoded sequence parameter set.
+type SPS struct {
+	Profile        uint8
+	Constraints    uint8
+	Level          uint8
+	ID             uint32
+	ChromaFormat   uint8
+	SeparatePlanes bool
+	BitDepthLuma   uint8
+	BitDepthChroma uint8
+
+	Log2MaxFrameNum    uint32
+	PicOrderCntType    uint32
+	Log2MaxPicOrderLsb uint32
+	MaxRefFrames       uint32
+	FrameMbsOnly       bool
+
+	// Picture size in pixels with cropping applied
+	Width  int
+	Height int
+
+	CropLeft   uint32
+	CropRight  uint32
+	CropTop    uint32
+	CropBottom uint32
+
+	VUI *VUI
+}
+
+// VUI represents the video usability information of the SPS.
+type VUI struct {
+	SarWidth                uint16
+	SarHeight               uint16
+	VideoFormat             uint8
+	FullRange               bool
+	ColourPrimaries         uint8
+	TransferCharacteristics uint8
+	MatrixCoefficients      uint8
+	TimingInfo              bool
+	NumUnitsInTick          uint32
+	TimeScale               uint32
+	FixedFrameRate          bool
+}
+
+// FrameRate returns the frame rate defined by VUI timing info or zero if unknown.
+func (s *SPS) FrameRate() float64 {
+	if v := s.VUI; v != nil && v.TimingInfo && v.NumUnitsInTick > 0 {
+		return float64(v.TimeScale) / float64(2*v.NumUnitsInTick)
+	}
+	return 0
+}
+
+var sarTable = [...][2]uint16{
+	{0, 0}, {1, 1}, {12, 11}, {10, 11}, {16, 11}, {40, 33}, {24, 11}, {20, 11},
+	{32, 11}, {80, 33}, {18, 11}, {15, 11}, {64, 33}, {160, 99}, {4, 3}, {3, 2}, {2, 1},
+}
+
+// ParseSPS parses the sequence parameter set NAL unit.
+func ParseSPS(nal []byte) (*SPS, error) {
+	if NALType(nal) != NALSPS {
+		return nil, ErrFormat
+	}
+	r := bits.NewReader(bits.Unescape(nal[1:]))
+	s := &SPS{
+		Profile:        uint8(r.ReadBits(8)),
+		Constraints:    uint8(r.ReadBits(8)),
+		Level:          uint8(r.ReadBits(8)),
+		ID:             r.ReadUE(),
+		ChromaFormat:   1,
+		BitDepthLuma:   8,
+		BitDepthChroma: 8,
+	}
+	switch s.Profile {
+	case 100, 110, 122, 244, 44, 83, 86, 118, 128, 138, 139, 134, 135:
+		s.ChromaFormat = uint8(r.ReadUE())
+		if s.ChromaFormat == 3 {
+			s.SeparatePlanes = r.ReadFlag()
+		}
+		s.BitDepthLuma = uint8(r.ReadUE() + 8)
+		s.BitDepthChroma = uint8(r.ReadUE() + 8)
+		r.Skip(1) // qpprime_y_zero_transform_bypass_flag
+		if r.ReadFlag() {
+			n := 8
+			if s.ChromaFormat == 3 {
+				n = 12
+			}
+			for i := 0; i < n; i++ {
+				if !r.ReadFlag() {
+					continue
+				}
+				if i < 6 {
+					skipScalingList(r, 16)
+				} else {
+					skipScalingList(r, 64)
+				}
+			}
+		}
+	}
+	s.Log2MaxFrameNum = r.ReadUE() + 4
+	switch s.PicOrderCntType = r.ReadUE(); s.PicOrderCntType {
+	case 0:
+		s.Log2MaxPicOrderLsb = r.ReadUE() + 4
+	case 1:
+		r.Skip(1) // delta_pic_order_always_zero_flag
+		r.ReadSE()
+		r.ReadSE()
+		for n := r.ReadUE(); n > 0 && r.Err() == nil; n-- {
+			r.ReadSE()
+		}
+	}
+	s.MaxRefFrames = r.ReadUE()
+	r.Skip(1) // gaps_in_frame_num_value_allowed_flag
+	w := int(r.ReadUE() + 1)
+	h := int(r.ReadUE() + 1)
+	if s.FrameMbsOnly = r.ReadFlag(); !s.FrameMbsOnly {
+		r.Skip(1) // mb_adaptive_frame_field_flag
+		h <<= 1
+	}
+	r.Skip(1) // direct_8x8_inference_flag
+	if r.ReadFlag() {
+		s.CropLeft = r.ReadUE()
+		s.CropRight = r.ReadUE()
+		s.CropTop = r.ReadUE()
+		s.CropBottom = r.ReadUE()
+	}
+	cx, cy := 1, 1
+	if !s.SeparatePlanes && s.ChromaFormat != 0 {
+		if s.ChromaFormat < 3 {
+			cx = 2
+		}
+		if s.ChromaFormat == 1 {
+			cy = 2
+		}
+	}
+	if !s.FrameMbsOnly {
+		cy <<= 1
+	}
+	s.Width = w*16 - cx*int(s.CropLeft+s.CropRight)
+	s.Height = h*16 - cy*int(s.CropTop+s.CropBottom)
+	if r.ReadFlag() {
+		s.VUI = parseVUI(r)
+	}
+	if err := r.Err(); err != nil {
+		return nil, ErrFormat
+	}
+	return s, nil
+}
+
+func parseVUI(r *bits.Reader) *VUI {
+	v := &VUI{
+		VideoFormat:             5,
+		ColourPrimaries:         2,
+		TransferCharacteristics: 2,
+		MatrixCoefficients:      2,
+	}
+	if r.ReadFlag() {
+		if i := r.ReadBits(8); i == 255 {
+			v.SarWidth = uint16(r.ReadBits(16))
+			v.SarHeight = uint16(r.ReadBits(16))
+		} else if int(i) < len(sarTable) {
+			v.SarWidth, v.SarHeight = sarTable[i][0], sarTable[i][1]
+		}
+	}
+	if r.ReadFlag() {
+		r.Skip(1) // overscan_appropriate_flag
+	}
+	if r.ReadFlag() {
+		v.VideoFormat = uint8(r.ReadBits(3))
+		v.FullRange = r.ReadFlag()
+		if r.ReadFlag() {
+			v.ColourPrimaries = uint8(r.ReadBits(8))
+			v.TransferCharacteristics = uint8(r.ReadBits(8))
+			v.MatrixCoefficients = uint8(r.ReadBits(8))
+		}
+	}
+	if r.ReadFlag() {
+		r.ReadUE()
+		r.ReadUE()
+	}
+	if v.TimingInfo = r.ReadFlag(); v.TimingInfo {
+		v.NumUnitsInTick = r.ReadBits(32)
+		v.TimeScale = r.ReadBits(32)
+		v.FixedFrameRate = r.ReadFlag()
+	}
+	return v
+}
+
+func skipScalingList(r *bits.Reader, n int) {
+	last, next := int32(8), int32(8)
+	for i := 0; i < n && r.Err() == nil; i++ {
+		if next != 0 {
+			next = (last + r.ReadSE() + 256) % 256
+		}
+		if next != 0 {
+			last = next
+		}
+	}
+}
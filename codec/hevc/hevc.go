@@ -0,0 +1,189 @@
+// Package hevc implements parsing of HEVC decoder configuration records and sequence parameter sets.
+package hevc
+
+import (
+	"errors"
+
+	"github.com/pixelbender/go-rtmp/flv"
+)
+
+// ErrFormat is returned when data is not a valid HEVC syntax.
+var ErrFormat = errors.New("hevc: incorrect format")
+
+const (
+	NALTrailN   = uint8(0)
+	NALTrailR   = uint8(1)
+	NALBLAWLP   = uint8(16)
+	NALIDRWRADL = uint8(19)
+	NALIDRNLP   = uint8(20)
+	NALCRA      = uint8(21)
+	NALVPS      = uint8(32)
+	NALSPS      = uint8(33)
+	NALPPS      = uint8(34)
+	NALAUD      = uint8(35)
+	NALSEI      = uint8(39)
+)
+
+// NALType returns the type of NAL unit.
+func NALType(nal []byte) uint8 {
+	if len(nal) == 0 {
+		return 0
+	}
+	return nal[0] >> 1 & 0x3f
+}
+
+// IsKeyFrame reports whether the NAL unit type is an intra random access point.
+func IsKeyFrame(typ uint8) bool {
+	return NALBLAWLP <= typ && typ <= 23
+}
+
+// DecoderConfig represents the HEVCDecoderConfigurationRecord.
+type DecoderConfig struct {
+	Version                uint8
+	ProfileSpace           uint8
+	Tier                   uint8
+	Profile                uint8
+	ProfileCompatibility   uint32
+	ConstraintIndicator    uint64
+	Level                  uint8
+	MinSpatialSegmentation uint16
+	Parallelism            uint8
+	ChromaFormat           uint8
+	BitDepthLuma           uint8
+	BitDepthChroma         uint8
+	AvgFrameRate           uint16
+	ConstantFrameRate      uint8
+	NumTemporalLayers      uint8
+	TemporalIDNested       bool
+	LengthSize             int
+	Arrays                 []*NALArray
+}
+
+// NALArray represents an array of parameter set NAL units of the same type.
+type NALArray struct {
+	Complete bool
+	Type     uint8
+	NALUs    [][]byte
+}
+
+// ParseSequenceHeader parses the decoder configuration from FLV video tag data or RTMP video message.
+func ParseSequenceHeader(data []byte) (*DecoderConfig, error) {
+	h, n, err := flv.ParseVideoHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if h.FourCC != flv.FourCCHEVC || !h.IsSequenceHeader() {
+		return nil, ErrFormat
+	}
+	return ParseDecoderConfig(data[n:])
+}
+
+// ParseDecoderConfig parses the HEVCDecoderConfigurationRecord.
+func ParseDecoderConfig(b []byte) (*DecoderConfig, error) {
+	if len(b) < 23 || b[0] != 1 {
+		return nil, ErrFormat
+	}
+	c := &DecoderConfig{
+		Version:                b[0],
+		ProfileSpace:           b[1] >> 6,
+		Tier:                   b[1] >> 5 & 0x01,
+		Profile:                b[1] & 0x1f,
+		ProfileCompatibility:   be.Uint32(b[2:]),
+		ConstraintIndicator:    be.Uint64(b[4:]) & 0xffffffffffff,
+		Level:                  b[12],
+		MinSpatialSegmentation: be.Uint16(b[13:]) & 0x0fff,
+		Parallelism:            b[15] & 0x03,
+		ChromaFormat:           b[16] & 0x03,
+		BitDepthLuma:           b[17]&0x07 + 8,
+		BitDepthChroma:         b[18]&0x07 + 8,
+		AvgFrameRate:           be.Uint16(b[19:]),
+		ConstantFrameRate:      b[21] >> 6,
+		NumTemporalLayers:      b[21] >> 3 & 0x07,
+		TemporalIDNested:       b[21]&0x04 != 0,
+		LengthSize:             int(b[21]&0x03) + 1,
+	}
+	n := int(b[22])
+	for b = b[23:]; n > 0; n-- {
+		if len(b) < 3 {
+			return nil, ErrFormat
+		}
+		a := &NALArray{
+			Complete: b[0]&0x80 != 0,
+			Type:     b[0] & 0x3f,
+		}
+		k := int(be.Uint16(b[1:]))
+		for b = b[3:]; k > 0; k-- {
+			if len(b) < 2 {
+				return nil, ErrFormat
+			}
+			size := int(be.Uint16(b))
+			if len(b) < 2+size {
+				return nil, ErrFormat
+			}
+			a.NALUs, b = append(a.NALUs, b[2:2+size:2+size]), b[2+size:]
+		}
+		c.Arrays = append(c.Arrays, a)
+	}
+	return c, nil
+}
+
+// Bytes returns the binary representation of the HEVCDecoderConfigurationRecord.
+func (c *DecoderConfig) Bytes() []byte {
+	b := make([]byte, 23, 64)
+	b[0] = 1
+	b[1] = c.ProfileSpace<<6 | c.Tier<<5 | c.Profile&0x1f
+	be.PutUint32(b[2:], c.ProfileCompatibility)
+	v := c.ConstraintIndicator
+	for i := 11; i >= 6; i-- {
+		b[i], v = byte(v), v>>8
+	}
+	b[12] = c.Level
+	be.PutUint16(b[13:], 0xf000|c.MinSpatialSegmentation)
+	b[15] = 0xfc | c.Parallelism
+	b[16] = 0xfc | c.ChromaFormat
+	b[17] = 0xf8 | (c.BitDepthLuma-8)&0x07
+	b[18] = 0xf8 | (c.BitDepthChroma-8)&0x07
+	be.PutUint16(b[19:], c.AvgFrameRate)
+	b[21] = c.ConstantFrameRate<<6 | c.NumTemporalLayers&0x07<<3 | uint8(c.LengthSize-1)&0x03
+	if c.TemporalIDNested {
+		b[21] |= 0x04
+	}
+	b[22] = uint8(len(c.Arrays))
+	for _, a := range c.Arrays {
+		h := a.Type & 0x3f
+		if a.Complete {
+			h |= 0x80
+		}
+		b = append(b, h, uint8(len(a.NALUs)>>8), uint8(len(a.NALUs)))
+		for _, it := range a.NALUs {
+			b = append(b, uint8(len(it)>>8), uint8(len(it)))
+			b = append(b, it...)
+		}
+	}
+	return b
+}
+
+// NALUs returns parameter set NAL units of the given type.
+func (c *DecoderConfig) NALUs(typ uint8) (r [][]byte) {
+	for _, a := range c.Arrays {
+		if a.Type == typ {
+			r = append(r, a.NALUs...)
+		}
+	}
+	return
+}
+
+// VPS returns video parameter sets.
+func (c *DecoderConfig) VPS() [][]byte {
+	return c.NALUs(NALVPS)
+}
+
+// SPS returns sequence parameter sets.
+func (c *DecoderConfig) SPS() [][]byte {
+	return c.NALUs(NALSPS)
+}
+
+// PPS returns picture parameter sets.
+func (c *DecoderConfig) PPS() [][]byte {
+	return c.NALUs(NALPPS)
+}
@@ -0,0 +1,50 @@
+package hevc
+
+import (
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+func decodeHex(t *testing.T, h string) []byte {
+	b, err := hex.DecodeString(h)
+	if err != nil {
+		t.Fatal("hex:", h)
+	}
+	return b
+}
+
+func TestDecoderConfig(t *testing.T) {
+	c := &DecoderConfig{
+		Version:              1,
+		Profile:              1,
+		ProfileCompatibility: 0x60000000,
+		ConstraintIndicator:  0x900000000000,
+		Level:                93,
+		ChromaFormat:         1,
+		BitDepthLuma:         8,
+		BitDepthChroma:       8,
+		NumTemporalLayers:    1,
+		TemporalIDNested:     true,
+		LengthSize:           4,
+		Arrays: []*NALArray{
+			{true, NALVPS, [][]byte{decodeHex(t, "40010c01ffff01600000030090000003000003005d959809")}},
+			{true, NALSPS, [][]byte{decodeHex(t, "42010101600000030090000003000003005da00280802d165959a4932bc05a70800001f480003a9804")}},
+			{true, NALPPS, [][]byte{decodeHex(t, "4401c172b46240")}},
+		},
+	}
+	r, err := ParseSequenceHeader(append([]byte{0x1c, 0, 0, 0, 0}, c.Bytes()...))
+	if err != nil {
+		t.Fatal("parse config:", err)
+	}
+	if !reflect.DeepEqual(c, r) {
+		t.Fatalf("parse config: %+v != %+v", r, c)
+	}
+	s, err := ParseSPS(r.SPS()[0])
+	if err != nil {
+		t.Fatal("parse sps:", err)
+	}
+	if s.Width != 1280 || s.Height != 720 || s.Profile != 1 || s.Level != 93 || s.ChromaFormat != 1 || s.BitDepthLuma != 8 {
+		t.Fatalf("parse sps: %+v", s)
+	}
+}
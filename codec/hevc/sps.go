@@ -0,0 +1,100 @@
+package hevc
+
+import (
+	"encoding/binary"
+
+	"github.com/pixelbender/go-rtmp/codec/internal/bits"
+)
+
+// SPS represents the leading fields of a sequence parameter set.
+type SPS struct {
+	VPSID                uint8
+	MaxSubLayers         uint8
+	TemporalIDNesting    bool
+	ProfileSpace         uint8
+	Tier                 uint8
+	Profile              uint8
+	ProfileCompatibility uint32
+	Level                uint8
+	ID                   uint32
+	ChromaFormat         uint8
+	SeparatePlanes       bool
+	BitDepthLuma         uint8
+	BitDepthChroma       uint8
+
+	// Picture size in pixels with conformance window applied
+	Width  int
+	Height int
+
+	ConfLeft   uint32
+	ConfRight  uint32
+	ConfTop    uint32
+	ConfBottom uint32
+}
+
+// ParseSPS parses the sequence parameter set NAL unit.
+func ParseSPS(nal []byte) (*SPS, error) {
+	if len(nal) < 3 || NALType(nal) != NALSPS {
+		return nil, ErrFormat
+	}
+	r := bits.NewReader(bits.Unescape(nal[2:]))
+	s := &SPS{
+		VPSID:             uint8(r.ReadBits(4)),
+		MaxSubLayers:      uint8(r.ReadBits(3) + 1),
+		TemporalIDNesting: r.ReadFlag(),
+	}
+	s.ProfileSpace = uint8(r.ReadBits(2))
+	s.Tier = uint8(r.ReadBits(1))
+	s.Profile = uint8(r.ReadBits(5))
+	s.ProfileCompatibility = r.ReadBits(32)
+	r.Skip(48) // general constraint indicator flags
+	s.Level = uint8(r.ReadBits(8))
+	n := int(s.MaxSubLayers) - 1
+	profile, level := make([]bool, n), make([]bool, n)
+	for i := 0; i < n; i++ {
+		profile[i] = r.ReadFlag()
+		level[i] = r.ReadFlag()
+	}
+	if n > 0 {
+		r.Skip(2 * (8 - n))
+	}
+	for i := 0; i < n; i++ {
+		if profile[i] {
+			r.Skip(88)
+		}
+		if level[i] {
+			r.Skip(8)
+		}
+	}
+	s.ID = r.ReadUE()
+	if s.ChromaFormat = uint8(r.ReadUE()); s.ChromaFormat == 3 {
+		s.SeparatePlanes = r.ReadFlag()
+	}
+	w := int(r.ReadUE())
+	h := int(r.ReadUE())
+	if r.ReadFlag() {
+		s.ConfLeft = r.ReadUE()
+		s.ConfRight = r.ReadUE()
+		s.ConfTop = r.ReadUE()
+		s.ConfBottom = r.ReadUE()
+	}
+	s.BitDepthLuma = uint8(r.ReadUE() + 8)
+	s.BitDepthChroma = uint8(r.ReadUE() + 8)
+	if err := r.Err(); err != nil {
+		return nil, ErrFormat
+	}
+	cx, cy := 1, 1
+	if !s.SeparatePlanes && s.ChromaFormat != 0 {
+		if s.ChromaFormat < 3 {
+			cx = 2
+		}
+		if s.ChromaFormat == 1 {
+			cy = 2
+		}
+	}
+	s.Width = w - cx*int(s.ConfLeft+s.ConfRight)
+	s.Height = h - cy*int(s.ConfTop+s.ConfBottom)
+	return s, nil
+}
+
+var be = binary.BigEndian
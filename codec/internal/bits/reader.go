@@ -0,0 +1,144 @@
+// Package bits implements a bit reader for codec bitstream syntax.
+package bits
+
+import (
+	"errors"
+)
+
+// ErrOverflow is returned when reading beyond the end of data.
+var ErrOverflow = errors.New("bits: read overflow")
+
+// Reader reads MSB-first bit fields from a byte slice.
+// The first error is sticky and reads after it return zero values.
+type Reader struct {
+	buf []byte
+	pos int
+	err error
+}
+
+// NewReader returns a new reader that reads from b.
+func NewReader(b []byte) *Reader {
+	return &Reader{buf: b}
+}
+
+// Err returns the first error occurred during reading.
+func (r *Reader) Err() error {
+	return r.err
+}
+
+// Left returns the number of unread bits.
+func (r *Reader) Left() int {
+	return len(r.buf)<<3 - r.pos
+}
+
+// Skip skips n bits.
+func (r *Reader) Skip(n int) {
+	if r.err != nil {
+		return
+	}
+	if n > r.Left() {
+		r.err, r.pos = ErrOverflow, len(r.buf)<<3
+		return
+	}
+	r.pos += n
+}
+
+// ReadBit reads a single bit.
+func (r *Reader) ReadBit() uint32 {
+	return r.ReadBits(1)
+}
+
+// ReadFlag reads a single bit as bool.
+func (r *Reader) ReadFlag() bool {
+	return r.ReadBits(1) != 0
+}
+
+// ReadBits reads up to 32 bits.
+func (r *Reader) ReadBits(n int) (v uint32) {
+	return uint32(r.ReadBits64(n))
+}
+
+// ReadBits64 reads up to 64 bits.
+func (r *Reader) ReadBits64(n int) (v uint64) {
+	if r.err != nil {
+		return
+	}
+	if n > r.Left() {
+		r.err, r.pos = ErrOverflow, len(r.buf)<<3
+		return
+	}
+	for ; n > 0; n-- {
+		v = v<<1 | uint64(r.buf[r.pos>>3]>>(7-uint(r.pos&7))&1)
+		r.pos++
+	}
+	return
+}
+
+// ReadUE reads an unsigned Exp-Golomb code.
+func (r *Reader) ReadUE() uint32 {
+	n := 0
+	for r.err == nil && r.ReadBits(1) == 0 {
+		if n++; n > 31 {
+			r.err = ErrOverflow
+			return 0
+		}
+	}
+	return uint32(1)<<uint(n) - 1 + r.ReadBits(n)
+}
+
+// ReadSE reads a signed Exp-Golomb code.
+func (r *Reader) ReadSE() int32 {
+	v := r.ReadUE()
+	if v&1 != 0 {
+		return int32(v>>1) + int32(v&1)
+	}
+	return -int32(v >> 1)
+}
+
+// ReadLEB128 reads an unsigned little-endian base 128 value at a byte boundary.
+func (r *Reader) ReadLEB128() (v uint64) {
+	for i := uint(0); i < 8; i++ {
+		b := r.ReadBits(8)
+		v |= uint64(b&0x7f) << (i * 7)
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	return
+}
+
+// Align skips bits up to the next byte boundary.
+func (r *Reader) Align() {
+	if n := r.pos & 7; n > 0 {
+		r.Skip(8 - n)
+	}
+}
+
+// Unescape removes emulation prevention bytes from a NAL unit payload.
+func Unescape(b []byte) []byte {
+	n := 0
+	for i := 2; i < len(b); i++ {
+		if b[i] == 0x03 && b[i-1] == 0 && b[i-2] == 0 {
+			n++
+			i += 2
+		}
+	}
+	if n == 0 {
+		return b
+	}
+	r := make([]byte, 0, len(b)-n)
+	z := 0
+	for _, c := range b {
+		if z >= 2 && c == 0x03 {
+			z = 0
+			continue
+		}
+		if c == 0 {
+			z++
+		} else {
+			z = 0
+		}
+		r = append(r, c)
+	}
+	return r
+}
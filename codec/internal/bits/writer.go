@@ -0,0 +1,34 @@
+package bits
+
+// Writer writes MSB-first bit fields to a byte slice.
+type Writer struct {
+	buf []byte
+	pos int
+}
+
+// WriteBits writes n low bits of v.
+func (w *Writer) WriteBits(v uint32, n int) {
+	for n--; n >= 0; n-- {
+		if w.pos&7 == 0 {
+			w.buf = append(w.buf, 0)
+		}
+		if v>>uint(n)&1 != 0 {
+			w.buf[w.pos>>3] |= 0x80 >> uint(w.pos&7)
+		}
+		w.pos++
+	}
+}
+
+// WriteFlag writes a single bit.
+func (w *Writer) WriteFlag(v bool) {
+	if v {
+		w.WriteBits(1, 1)
+	} else {
+		w.WriteBits(0, 1)
+	}
+}
+
+// Bytes returns written bits padded with zeros to a byte boundary.
+func (w *Writer) Bytes() []byte {
+	return w.buf
+}
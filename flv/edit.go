@@ -0,0 +1,240 @@
+package flv
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+
+	"github.com/pixelbender/go-rtmp/amf"
+)
+
+// Cut copies tags in the time range [from, to) milliseconds from r to w.
+// The output starts at the first video key frame at or after from, or at the first tag of an audio-only stream,
+// and is preceded by the latest metadata and sequence headers. Timestamps are rebased to start at zero.
+// A negative to copies tags until the end of stream.
+func Cut(w *Writer, r *Reader, from, to int64) error {
+	h, err := r.ReadHeader()
+	if err != nil {
+		return err
+	}
+	if err = w.WriteHeader(h); err != nil {
+		return err
+	}
+	s := newSplicer(w)
+	video := h.Flags&FlagVideo != 0
+	for {
+		tag, data, err := readTag(r)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		if s.started {
+			if to >= 0 && tag.Time >= to {
+				break
+			}
+		} else if k := headerKind(tag, data); k >= 0 {
+			s.hold(k, data)
+			continue
+		} else if tag.Time < from || tag.Type == TypeData || video && !isKeyFrame(tag, data) {
+			continue
+		} else if err = s.start(tag.Time); err != nil {
+			return err
+		}
+		if err = s.write(tag, data); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+// Concat copies tags of all readers to w one after another with continuous timestamps starting at zero.
+// Metadata is taken from the first reader, sequence headers are written whenever they change.
+func Concat(w *Writer, rs ...*Reader) error {
+	s := newSplicer(w)
+	for i, r := range rs {
+		h, err := r.ReadHeader()
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			if err = w.WriteHeader(h); err != nil {
+				return err
+			}
+		}
+		s.next()
+		for {
+			tag, data, err := readTag(r)
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+			if !s.started {
+				if k := headerKind(tag, data); k >= 0 {
+					s.hold(k, data)
+					continue
+				} else if tag.Type == TypeData {
+					continue
+				} else if err = s.start(tag.Time); err != nil {
+					return err
+				}
+			}
+			if err = s.write(tag, data); err != nil {
+				return err
+			}
+		}
+	}
+	return w.Flush()
+}
+
+// Rebase copies tags from r to w shifting timestamps to start at zero.
+func Rebase(w *Writer, r *Reader) error {
+	return Concat(w, r)
+}
+
+const (
+	headerMeta = iota
+	headerVideo
+	headerAudio
+)
+
+// splicer writes tags of consecutive inputs keeping timestamps continuous.
+type splicer struct {
+	w       *Writer
+	pending [3][]byte
+	sent    [3][]byte
+	started bool
+	base    int64
+	offset  int64
+	last    int64
+	prev    [2]int64 // time of the last video and audio frame of the input
+	dur     [2]int64 // shortest video and audio frame duration of the input, not affected by stream drops
+}
+
+func newSplicer(w *Writer) *splicer {
+	s := &splicer{w: w}
+	s.next()
+	return s
+}
+
+// next prepares the splicer for the next input starting one frame after the last tag.
+func (s *splicer) next() {
+	if s.started {
+		d := s.dur[0]
+		if s.dur[1] > d {
+			d = s.dur[1]
+		}
+		if d == 0 {
+			d = 1
+		}
+		s.offset = s.last + d
+	}
+	s.started = false
+	s.pending = [3][]byte{}
+	s.prev = [2]int64{-1, -1}
+	s.dur = [2]int64{}
+}
+
+func (s *splicer) hold(k int, data []byte) {
+	s.pending[k] = append(s.pending[k][:0], data...)
+}
+
+func (s *splicer) start(t int64) error {
+	s.started, s.base, s.last = true, t, s.offset
+	for k, b := range s.pending {
+		if b == nil || k == headerMeta && s.sent[k] != nil {
+			continue
+		}
+		if err := s.writeHeader(k, &Tag{Type: headerType(k), Time: s.offset}, b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *splicer) write(tag *Tag, data []byte) error {
+	t := tag.Time - s.base + s.offset
+	if t < s.offset {
+		t = s.offset
+	}
+	if k := headerKind(tag, data); k >= 0 {
+		return s.writeHeader(k, &Tag{Type: tag.Type, Time: t, Stream: tag.Stream}, data)
+	}
+	if i := frameIndex(tag.Type); i >= 0 {
+		if d := t - s.prev[i]; s.prev[i] >= 0 && d > 0 && (s.dur[i] == 0 || d < s.dur[i]) {
+			s.dur[i] = d
+		}
+		s.prev[i] = t
+	}
+	if t > s.last {
+		s.last = t
+	}
+	return s.w.WriteTag(&Tag{Type: tag.Type, Time: t, Stream: tag.Stream}, data)
+}
+
+func (s *splicer) writeHeader(k int, tag *Tag, data []byte) error {
+	if bytes.Equal(data, s.sent[k]) {
+		return nil
+	}
+	s.sent[k] = append(s.sent[k][:0], data...)
+	return s.w.WriteTag(tag, data)
+}
+
+// frameIndex returns the index of the tag type in the frame durations, -1 for data tags.
+func frameIndex(typ uint8) int {
+	switch typ {
+	case TypeVideo:
+		return 0
+	case TypeAudio:
+		return 1
+	}
+	return -1
+}
+
+func headerType(k int) uint8 {
+	switch k {
+	case headerVideo:
+		return TypeVideo
+	case headerAudio:
+		return TypeAudio
+	}
+	return TypeData
+}
+
+func headerKind(tag *Tag, data []byte) int {
+	switch tag.Type {
+	case TypeData:
+		if name, err := amf.NewDecoder(0, data).ReadString(); err == nil && name == "onMetaData" {
+			return headerMeta
+		}
+	case TypeVideo:
+		if h, _, err := ParseVideoHeader(data); err == nil && h.IsSequenceHeader() {
+			return headerVideo
+		}
+	case TypeAudio:
+		if h, _, err := ParseAudioHeader(data); err == nil && h.IsSequenceHeader() {
+			return headerAudio
+		}
+	}
+	return -1
+}
+
+func isKeyFrame(tag *Tag, data []byte) bool {
+	if tag.Type != TypeVideo {
+		return false
+	}
+	h, _, err := ParseVideoHeader(data)
+	return err == nil && h.IsKeyFrame()
+}
+
+func readTag(r *Reader) (tag *Tag, data []byte, err error) {
+	var d io.Reader
+	if tag, d, err = r.ReadTag(); err != nil {
+		return
+	}
+	if data, err = ioutil.ReadAll(d); err == nil && len(data) != tag.Size {
+		err = io.ErrUnexpectedEOF
+	}
+	return
+}
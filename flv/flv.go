@@ -1,15 +1,18 @@
 package flv
 
+// Header represents the FLV file header.
 type Header struct {
 	Signature uint32
 	Version   uint8
 	Flags     uint8
 }
 
+// NewHeader returns a FLV header with the given type flags.
 func NewHeader(flags uint8) *Header {
 	return &Header{sign, 1, flags}
 }
 
+// Tag represents the FLV tag header.
 type Tag struct {
 	Type   uint8
 	Size   int
@@ -23,4 +26,218 @@ const (
 	TypeData  = uint8(0x12)
 )
 
+const (
+	FlagVideo = uint8(0x01)
+	FlagAudio = uint8(0x04)
+)
+
+const (
+	FrameKey        = uint8(0x1)
+	FrameInter      = uint8(0x2)
+	FrameDisposable = uint8(0x3)
+	FrameGenerated  = uint8(0x4)
+	FrameCommand    = uint8(0x5)
+)
+
+const (
+	CodecH263    = uint8(0x2)
+	CodecScreen  = uint8(0x3)
+	CodecVP6     = uint8(0x4)
+	CodecVP6A    = uint8(0x5)
+	CodecScreen2 = uint8(0x6)
+	CodecAVC     = uint8(0x7)
+	CodecHEVC    = uint8(0xc) // non-standard, widely used before Enhanced FLV
+)
+
+// Enhanced FLV video packet types.
+// Values of the first three match AVC packet types.
+const (
+	PacketSequenceStart        = uint8(0x0)
+	PacketCodedFrames          = uint8(0x1)
+	PacketSequenceEnd          = uint8(0x2)
+	PacketCodedFramesX         = uint8(0x3)
+	PacketMetadata             = uint8(0x4)
+	PacketMPEG2TSSequenceStart = uint8(0x5)
+)
+
+// Enhanced FLV codec FourCC identifiers.
+const (
+	FourCCAVC  = uint32(0x61766331) // avc1
+	FourCCHEVC = uint32(0x68766331) // hvc1
+	FourCCAV1  = uint32(0x61763031) // av01
+	FourCCVP9  = uint32(0x76703039) // vp09
+)
+
+const (
+	AVCSequenceHeader = uint8(0x0)
+	AVCNALU           = uint8(0x1)
+	AVCEndOfSequence  = uint8(0x2)
+)
+
+const (
+	SoundPCM        = uint8(0x0)
+	SoundADPCM      = uint8(0x1)
+	SoundMP3        = uint8(0x2)
+	SoundPCMLE      = uint8(0x3)
+	SoundNellymoser = uint8(0x6)
+	SoundG711A      = uint8(0x7)
+	SoundG711U      = uint8(0x8)
+	SoundExHeader   = uint8(0x9)
+	SoundAAC        = uint8(0xa)
+	SoundSpeex      = uint8(0xb)
+)
+
+// Enhanced FLV audio codec FourCC identifiers.
+// Audio packet types share values with video packet types.
+const (
+	FourCCAAC  = uint32(0x6d703461) // mp4a
+	FourCCOpus = uint32(0x4f707573) // Opus
+	FourCCFLAC = uint32(0x664c6143) // fLaC
+	FourCCAC3  = uint32(0x61632d33) // ac-3
+	FourCCEAC3 = uint32(0x65632d33) // ec-3
+	FourCCMP3  = uint32(0x2e6d7033) // .mp3
+)
+
+const (
+	AACSequenceHeader = uint8(0x0)
+	AACRaw            = uint8(0x1)
+)
+
+// VideoHeader represents the header of a legacy or Enhanced FLV video tag data.
+// FourCC is set for enhanced headers and for legacy AVC and HEVC headers.
+type VideoHeader struct {
+	Enhanced        bool
+	FrameType       uint8
+	Codec           uint8
+	FourCC          uint32
+	PacketType      uint8
+	CompositionTime int32
+}
+
+// ParseVideoHeader parses the video tag data header and returns the header length.
+func ParseVideoHeader(b []byte) (h *VideoHeader, n int, err error) {
+	if len(b) < 1 {
+		return nil, 0, ErrFormat
+	}
+	if b[0]&0x80 != 0 {
+		return parseVideoExHeader(b)
+	}
+	h = &VideoHeader{
+		FrameType: b[0] >> 4,
+		Codec:     b[0] & 0x0f,
+	}
+	n = 1
+	switch h.Codec {
+	case CodecAVC:
+		h.FourCC = FourCCAVC
+	case CodecHEVC:
+		h.FourCC = FourCCHEVC
+	default:
+		return
+	}
+	if len(b) < 5 {
+		return nil, 0, ErrFormat
+	}
+	h.PacketType = b[1]
+	h.CompositionTime = getInt24(b[2:])
+	n = 5
+	return
+}
+
+func parseVideoExHeader(b []byte) (h *VideoHeader, n int, err error) {
+	if len(b) < 5 {
+		return nil, 0, ErrFormat
+	}
+	h = &VideoHeader{
+		Enhanced:   true,
+		FrameType:  b[0] >> 4 & 0x07,
+		PacketType: b[0] & 0x0f,
+		FourCC:     getUint32(b[1:]),
+	}
+	n = 5
+	if h.PacketType == PacketCodedFrames && (h.FourCC == FourCCAVC || h.FourCC == FourCCHEVC) {
+		if len(b) < 8 {
+			return nil, 0, ErrFormat
+		}
+		h.CompositionTime = getInt24(b[5:])
+		n = 8
+	}
+	return
+}
+
+// IsKeyFrame reports whether the video tag data contains a key frame.
+func (h *VideoHeader) IsKeyFrame() bool {
+	return h.FrameType == FrameKey
+}
+
+// IsSequenceHeader reports whether the video tag data contains a decoder configuration.
+func (h *VideoHeader) IsSequenceHeader() bool {
+	return h.FourCC != 0 && h.PacketType == PacketSequenceStart
+}
+
+// IsCodedFrames reports whether the video tag data contains coded frames.
+func (h *VideoHeader) IsCodedFrames() bool {
+	if h.FourCC == 0 {
+		return h.FrameType != FrameCommand
+	}
+	return h.PacketType == PacketCodedFrames || h.PacketType == PacketCodedFramesX
+}
+
+// AudioHeader represents the header of a legacy or Enhanced FLV audio tag data.
+// FourCC is set for enhanced headers and for legacy AAC headers.
+type AudioHeader struct {
+	Enhanced   bool
+	Format     uint8
+	Rate       uint8
+	Size       uint8
+	Channels   uint8
+	FourCC     uint32
+	PacketType uint8
+}
+
+// ParseAudioHeader parses the audio tag data header and returns the header length.
+func ParseAudioHeader(b []byte) (h *AudioHeader, n int, err error) {
+	if len(b) < 1 {
+		return nil, 0, ErrFormat
+	}
+	if b[0]>>4 == SoundExHeader {
+		if len(b) < 5 {
+			return nil, 0, ErrFormat
+		}
+		h = &AudioHeader{
+			Enhanced:   true,
+			Format:     SoundExHeader,
+			PacketType: b[0] & 0x0f,
+			FourCC:     getUint32(b[1:]),
+		}
+		return h, 5, nil
+	}
+	h = &AudioHeader{
+		Format:   b[0] >> 4,
+		Rate:     b[0] >> 2 & 0x03,
+		Size:     b[0] >> 1 & 0x01,
+		Channels: b[0] & 0x01,
+	}
+	n = 1
+	if h.Format == SoundAAC {
+		if len(b) < 2 {
+			return nil, 0, ErrFormat
+		}
+		h.FourCC = FourCCAAC
+		h.PacketType = b[1]
+		n = 2
+	}
+	return
+}
+
+// IsSequenceHeader reports whether the audio tag data contains a decoder configuration.
+func (h *AudioHeader) IsSequenceHeader() bool {
+	return h.FourCC != 0 && h.PacketType == PacketSequenceStart
+}
+
+// IsCodedFrames reports whether the audio tag data contains coded frames.
+func (h *AudioHeader) IsCodedFrames() bool {
+	return h.FourCC == 0 || h.PacketType == PacketCodedFrames
+}
+
 const sign = uint32(0x464C56)
@@ -0,0 +1,147 @@
+package flv
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"testing"
+)
+
+var (
+	testMeta  = []byte{0x02, 0x00, 0x0a, 'o', 'n', 'M', 'e', 't', 'a', 'D', 'a', 't', 'a', 0x05}
+	testAVC   = []byte{0x17, 0x00, 0x00, 0x00, 0x00, 0x01, 0x64}
+	testAAC   = []byte{0xaf, 0x00, 0x12, 0x10}
+	testKey   = []byte{0x17, 0x01, 0x00, 0x00, 0x00, 0xaa}
+	testInter = []byte{0x27, 0x01, 0x00, 0x00, 0x00, 0xbb}
+	testAudio = []byte{0xaf, 0x01, 0xcc}
+)
+
+type testTag struct {
+	Type uint8
+	Time int64
+	Data []byte
+}
+
+func writeTags(t *testing.T, tags []testTag) *bytes.Buffer {
+	b := &bytes.Buffer{}
+	w := NewWriter(b)
+	if err := w.WriteHeader(NewHeader(FlagAudio | FlagVideo)); err != nil {
+		t.Fatal("write header:", err)
+	}
+	for _, it := range tags {
+		if err := w.WriteTag(&Tag{Type: it.Type, Time: it.Time}, it.Data); err != nil {
+			t.Fatal("write tag:", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal("flush:", err)
+	}
+	return b
+}
+
+func readTags(t *testing.T, r io.Reader) (tags []testTag) {
+	fr := NewReader(r)
+	for {
+		tag, data, err := readTag(fr)
+		if err == io.EOF {
+			return
+		} else if err != nil {
+			t.Fatal("read tag:", err)
+		}
+		tags = append(tags, testTag{tag.Type, tag.Time, data})
+	}
+}
+
+func testStream(base int64) []testTag {
+	return []testTag{
+		{TypeData, 0, testMeta},
+		{TypeVideo, base, testAVC},
+		{TypeAudio, base, testAAC},
+		{TypeVideo, base, testKey},
+		{TypeAudio, base + 20, testAudio},
+		{TypeVideo, base + 40, testInter},
+		{TypeVideo, base + 80, testKey},
+		{TypeAudio, base + 90, testAudio},
+		{TypeVideo, base + 0x1000000, testInter},
+	}
+}
+
+func TestReadWrite(t *testing.T) {
+	in := testStream(0)
+	out := readTags(t, writeTags(t, in))
+	if !reflect.DeepEqual(in, out) {
+		t.Fatalf("read: %v != %v", out, in)
+	}
+}
+
+func TestCut(t *testing.T) {
+	b := &bytes.Buffer{}
+	w := NewWriter(b)
+	if err := Cut(w, NewReader(writeTags(t, testStream(1000))), 1050, 1100); err != nil {
+		t.Fatal("cut:", err)
+	}
+	out := readTags(t, b)
+	exp := []testTag{
+		{TypeData, 0, testMeta},
+		{TypeVideo, 0, testAVC},
+		{TypeAudio, 0, testAAC},
+		{TypeVideo, 0, testKey},
+		{TypeAudio, 10, testAudio},
+	}
+	if !reflect.DeepEqual(exp, out) {
+		t.Fatalf("cut: %v != %v", out, exp)
+	}
+}
+
+func TestConcat(t *testing.T) {
+	b := &bytes.Buffer{}
+	w := NewWriter(b)
+	in := []testTag{
+		{TypeVideo, 500, testAVC},
+		{TypeVideo, 500, testKey},
+		{TypeVideo, 540, testInter},
+	}
+	if err := Concat(w, NewReader(writeTags(t, in)), NewReader(writeTags(t, in))); err != nil {
+		t.Fatal("concat:", err)
+	}
+	out := readTags(t, b)
+	exp := []testTag{
+		{TypeVideo, 0, testAVC},
+		{TypeVideo, 0, testKey},
+		{TypeVideo, 40, testInter},
+		{TypeVideo, 80, testKey},
+		{TypeVideo, 120, testInter},
+	}
+	if !reflect.DeepEqual(exp, out) {
+		t.Fatalf("concat: %v != %v", out, exp)
+	}
+}
+
+func TestConcatSparseData(t *testing.T) {
+	b := &bytes.Buffer{}
+	w := NewWriter(b)
+	cue := []byte{0x02, 0x00, 0x0a, 'o', 'n', 'C', 'u', 'e', 'P', 'o', 'i', 'n', 't', 0x05}
+	in := []testTag{
+		{TypeVideo, 0, testAVC},
+		{TypeVideo, 0, testKey},
+		{TypeData, 0, cue},
+		{TypeAudio, 10, testAudio},
+		{TypeVideo, 40, testInter},
+		{TypeAudio, 33, testAudio},
+		{TypeVideo, 500, testInter},
+		{TypeVideo, 540, testInter},
+		{TypeAudio, 543, testAudio},
+		{TypeData, 5000, cue},
+		{TypeVideo, 5000, testInter},
+	}
+	if err := Concat(w, NewReader(writeTags(t, in)), NewReader(writeTags(t, in[:3]))); err != nil {
+		t.Fatal("concat:", err)
+	}
+	out := readTags(t, b)
+	// The next input starts one frame after the last tag: video frames are 40ms here,
+	// the gap of the cue points and the video stall do not count.
+	exp := testTag{TypeVideo, 5040, testKey}
+	if it := out[len(out)-2]; !reflect.DeepEqual(it, exp) {
+		t.Fatalf("join: %v != %v", it, exp)
+	}
+}
@@ -23,19 +23,19 @@ type Reader struct {
 func NewReader(r io.Reader) *Reader {
 	seeker, _ := r.(io.ReadSeeker)
 	buf, _ := r.(*bufio.Reader)
-	if buf != nil {
+	if buf == nil {
 		buf = bufio.NewReader(r)
 	}
 	return &Reader{buf: buf, seeker: seeker, r: r}
 }
 
-// Read reads FLV header
+// ReadHeader reads FLV header.
 func (r *Reader) ReadHeader() (h *Header, err error) {
 	if h = r.header; h != nil {
 		return
 	}
 	var b []byte
-	if b, err = r.buf.Peek(13); err != nil {
+	if b, err = r.buf.Peek(9); err != nil {
 		return
 	}
 	h = &Header{
@@ -43,20 +43,21 @@ func (r *Reader) ReadHeader() (h *Header, err error) {
 		Version:   b[3],
 		Flags:     b[4],
 	}
-	off := getUint32(b[5:]) - 9
+	off := int64(getUint32(b[5:])) - 9
 	if h.Signature != sign || h.Version != 1 || off < 0 {
 		return nil, ErrFormat
 	}
-	if _, err = r.buf.Discard(15); err != nil {
+	if _, err = r.buf.Discard(9); err != nil {
 		return
 	}
 	if off > 0 {
-		r.skip = io.LimitReader(r.buf, off)
+		r.skip = &io.LimitedReader{R: r.buf, N: off}
 	}
+	r.header = h
 	return
 }
 
-// Read reads FLV tag and returns data reader.
+// ReadTag reads FLV tag and returns data reader.
 // Data reader is not valid after next Read.
 func (r *Reader) ReadTag() (tag *Tag, data io.Reader, err error) {
 	if r.header == nil {
@@ -64,26 +65,28 @@ func (r *Reader) ReadTag() (tag *Tag, data io.Reader, err error) {
 			return
 		}
 	}
-	r.Skip()
-	var b []byte
-	if b, err = r.buf.Peek(15); err != nil {
+	if err = r.Skip(); err != nil {
 		return
 	}
-	if _, err = r.buf.Discard(15); err != nil {
+	var b []byte
+	if b, err = r.buf.Peek(15); err != nil {
 		return
 	}
-	if p := getUint32(b); r.tag != nil && r.tag.Size+11 != p {
+	if p := int(getUint32(b)); r.tag != nil && r.tag.Size+11 != p {
 		err = ErrFormat
 		return
 	}
 	tag = &Tag{
 		Type:   b[4],
-		Size:   getInt24(b[5:]),
+		Size:   int(getUint24(b[5:])),
 		Time:   getTime(b[8:]),
 		Stream: getUint24(b[12:]),
 	}
-	data = io.LimitReader(r.buf, tag.Size)
-	r.tag, r.skip = tag, data
+	if _, err = r.buf.Discard(15); err != nil {
+		return
+	}
+	r.skip = &io.LimitedReader{R: r.buf, N: int64(tag.Size)}
+	r.tag, data = tag, r.skip
 	return
 }
 
@@ -96,17 +99,18 @@ func (r *Reader) Skip() (err error) {
 	if n > 0 {
 		b := int64(r.buf.Buffered())
 		if b < n && r.seeker != nil {
-			_, err = r.seeker.Seek(n-b, 1)
+			_, err = r.seeker.Seek(n-b, io.SeekCurrent)
 			r.buf.Reset(r.r)
 		} else {
-			_, err = r.buf.Discard(n)
+			_, err = r.buf.Discard(int(n))
 		}
 	}
+	r.skip = nil
 	return
 }
 
-func getInt24(b []byte) int {
-	return int(b[2]) | int(b[1])<<8 | int(b[0])<<16
+func getInt24(b []byte) int32 {
+	return int32(getUint24(b)<<8) >> 8
 }
 
 func getUint24(b []byte) uint32 {
@@ -2,100 +2,89 @@ package flv
 
 import (
 	"bufio"
-	"errors"
 	"io"
 )
 
-// ErrFormat is returned by Reader when a stream is not a valid FLV data.
-var ErrFormat = errors.New("flv: incorrect format")
-
-// Writer writes FLV header and tags from an input stream.
+// Writer writes FLV header and tags to an output stream.
 type Writer struct {
 	buf    *bufio.Writer
-	seeker io.WriteSeeker
-	r      io.Writer
-	skip   *io.LimitedReader
 	header *Header
-	tag    *Tag
+	b      [15]byte
 }
 
-// NewReader returns a new reader that reads from r.
+// NewWriter returns a new writer that writes to w.
 func NewWriter(w io.Writer) *Writer {
-	seeker, _ := w.(io.WriteSeeker)
 	buf, _ := w.(*bufio.Writer)
-	if buf != nil {
+	if buf == nil {
 		buf = bufio.NewWriter(w)
 	}
-	return &Writer{buf, seeker, w}
+	return &Writer{buf: buf}
 }
 
-// Read reads FLV header
-func (r *Reader) WriteHeader() (h *Header, err error) {
-	if h = r.header; h != nil {
+// WriteHeader writes FLV header.
+// It is written automatically with audio and video flags set before the first tag.
+func (w *Writer) WriteHeader(h *Header) (err error) {
+	if w.header != nil {
 		return
 	}
-	var b []byte
-	if b, err = r.buf.Peek(13); err != nil {
+	b := w.b[:13]
+	putUint24(b, sign)
+	b[3] = 1
+	b[4] = h.Flags
+	putUint32(b[5:], 9)
+	putUint32(b[9:], 0)
+	if _, err = w.buf.Write(b); err != nil {
 		return
 	}
-	h = &Header{
-		Signature: getUint24(b[0:]),
-		Version:   b[3],
-		Flags:     b[4],
-	}
-	off := getUint32(b[5:]) - 9
-	if h.Signature != sign || h.Version != 1 || off < 0 {
-		return nil, ErrFormat
-	}
-	if off > 0 {
-		r.skip = io.LimitReader(r.buf, off)
-	}
+	w.header = h
 	return
 }
 
-// Read reads FLV tag and returns data reader.
-// Data reader is not valid after next Read.
-func (r *Reader) ReadTag() (tag *Tag, data io.Reader, err error) {
-	if r.header == nil {
-		if _, err = r.ReadHeader(); err != nil {
+// WriteTag writes FLV tag with the given data.
+// The tag size is set to the length of data.
+func (w *Writer) WriteTag(tag *Tag, data []byte) (err error) {
+	if w.header == nil {
+		if err = w.WriteHeader(NewHeader(FlagAudio | FlagVideo)); err != nil {
 			return
 		}
 	}
-	r.Skip()
-	var b []byte
-	if b, err = r.buf.Peek(15); err != nil {
+	n := len(data)
+	b := w.b[:11]
+	b[0] = tag.Type
+	putUint24(b[1:], uint32(n))
+	putTime(b[4:], tag.Time)
+	putUint24(b[8:], tag.Stream)
+	if _, err = w.buf.Write(b); err != nil {
 		return
 	}
-	if p := getUint32(b); r.tag != nil && r.tag.Size+11 != p {
-		err = ErrFormat
+	if _, err = w.buf.Write(data); err != nil {
 		return
 	}
-	tag = &Tag{
-		Type:   b[4],
-		Size:   getInt24(b[5:]),
-		Time:   getTime(b[8:]),
-		Stream: getUint24(b[12:]),
-	}
-	data = io.LimitReader(r.buf, tag.Size)
-	r.tag, r.skip = tag, data
+	b = w.b[:4]
+	putUint32(b, uint32(n+11))
+	_, err = w.buf.Write(b)
 	return
 }
 
-// Skip skips unread data before next FLV tag
-func (r *Reader) Skip() (err error) {
-	if r.skip == nil {
-		return
-	}
-	n := r.skip.N
-	if n > 0 {
-		b := int64(r.buf.Buffered())
-		if b < n && r.seeker != nil {
-			_, err = r.seeker.Seek(n-b, 1)
-			r.buf.Reset(r.r)
-		} else {
-			_, err = r.buf.Discard(n)
-		}
-	}
-	r.skip = nil
-	return
+// Flush writes any buffered data to the underlying io.Writer.
+func (w *Writer) Flush() error {
+	return w.buf.Flush()
+}
+
+func putUint24(b []byte, v uint32) {
+	b[0] = byte(v >> 16)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v)
+}
+
+func putTime(b []byte, v int64) {
+	putUint24(b, uint32(v))
+	b[3] = byte(v >> 24)
+}
+
+func putUint32(b []byte, v uint32) {
+	b[0] = byte(v >> 24)
+	b[1] = byte(v >> 16)
+	b[2] = byte(v >> 8)
+	b[3] = byte(v)
 }
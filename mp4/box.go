@@ -0,0 +1,96 @@
+package mp4
+
+import (
+	"encoding/binary"
+)
+
+// buffer builds nested ISO BMFF boxes.
+type buffer struct {
+	b []byte
+}
+
+// start appends a box header and returns its offset for end.
+func (b *buffer) start(typ string) int {
+	off := len(b.b)
+	b.b = append(b.b, 0, 0, 0, 0)
+	b.b = append(b.b, typ...)
+	return off
+}
+
+// startFull appends a full box header and returns its offset for end.
+func (b *buffer) startFull(typ string, version uint8, flags uint32) int {
+	off := b.start(typ)
+	b.u32(uint32(version)<<24 | flags&0xffffff)
+	return off
+}
+
+// end sets the size of box started at off.
+func (b *buffer) end(off int) {
+	be.PutUint32(b.b[off:], uint32(len(b.b)-off))
+}
+
+// reserve appends a 32-bit placeholder and returns its offset.
+func (b *buffer) reserve() int {
+	off := len(b.b)
+	b.b = append(b.b, 0, 0, 0, 0)
+	return off
+}
+
+func (b *buffer) u8(v uint8) {
+	b.b = append(b.b, v)
+}
+
+func (b *buffer) u16(v uint16) {
+	b.b = append(b.b, byte(v>>8), byte(v))
+}
+
+func (b *buffer) u24(v uint32) {
+	b.b = append(b.b, byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (b *buffer) u32(v uint32) {
+	b.b = append(b.b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (b *buffer) u64(v uint64) {
+	b.u32(uint32(v >> 32))
+	b.u32(uint32(v))
+}
+
+func (b *buffer) zeros(n int) {
+	for ; n > 0; n-- {
+		b.b = append(b.b, 0)
+	}
+}
+
+func (b *buffer) bytes(v []byte) {
+	b.b = append(b.b, v...)
+}
+
+func (b *buffer) str(v string) {
+	b.b = append(b.b, v...)
+}
+
+// descriptor appends an MPEG-4 descriptor header with 4-byte length and returns its offset for endDescriptor.
+func (b *buffer) descriptor(tag uint8) int {
+	b.b = append(b.b, tag, 0x80, 0x80, 0x80, 0)
+	return len(b.b)
+}
+
+func (b *buffer) endDescriptor(off int) {
+	n := len(b.b) - off
+	b.b[off-4] = 0x80 | byte(n>>21&0x7f)
+	b.b[off-3] = 0x80 | byte(n>>14&0x7f)
+	b.b[off-2] = 0x80 | byte(n>>7&0x7f)
+	b.b[off-1] = byte(n & 0x7f)
+}
+
+var matrix = [9]uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000}
+
+func (b *buffer) matrix() {
+	for _, it := range matrix {
+		b.u32(it)
+	}
+}
+
+var be = binary.BigEndian
@@ -0,0 +1,137 @@
+package mp4
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	"github.com/pixelbender/go-rtmp/codec/aac"
+	"github.com/pixelbender/go-rtmp/codec/h264"
+	"github.com/pixelbender/go-rtmp/flv"
+)
+
+type testFile struct {
+	b   []byte
+	pos int
+}
+
+func (f *testFile) Write(p []byte) (int, error) {
+	if n := f.pos + len(p); n > len(f.b) {
+		f.b = append(f.b, make([]byte, n-len(f.b))...)
+	}
+	copy(f.b[f.pos:], p)
+	f.pos += len(p)
+	return len(p), nil
+}
+
+func (f *testFile) Seek(off int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		f.pos = int(off)
+	case io.SeekCurrent:
+		f.pos += int(off)
+	case io.SeekEnd:
+		f.pos = len(f.b) + int(off)
+	}
+	return int64(f.pos), nil
+}
+
+var containers = map[string]bool{
+	"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true, "dinf": true,
+	"mvex": true, "moof": true, "traf": true, "edts": true,
+}
+
+// walkBoxes checks nested box sizes and returns the list of box types.
+func walkBoxes(t *testing.T, b []byte, path string) (r []string) {
+	for len(b) > 0 {
+		if len(b) < 8 {
+			t.Fatalf("box %s: truncated", path)
+		}
+		size, typ, hdr := int(be.Uint32(b)), string(b[4:8]), 8
+		if size == 1 {
+			size, hdr = int(be.Uint64(b[8:])), 16
+		}
+		if size < hdr || size > len(b) {
+			t.Fatalf("box %s/%s: invalid size %d", path, typ, size)
+		}
+		r = append(r, path+"/"+typ)
+		if containers[typ] {
+			r = append(r, walkBoxes(t, b[hdr:size], path+"/"+typ)...)
+		}
+		b = b[size:]
+	}
+	return
+}
+
+func writeTestTags(t *testing.T, w *Writer) {
+	sps, _ := hex.DecodeString("6764002aacd940780227e5c044000003000400000300f03c60c658")
+	pps, _ := hex.DecodeString("68ebe3cb22c0")
+	vc, err := h264.NewDecoderConfig([][]byte{sps}, [][]byte{pps})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac := &aac.Config{ObjectType: aac.ObjectLC, SampleRate: 44100, Channels: 2, FrameLength: 1024}
+	tags := []struct {
+		typ  uint8
+		time int64
+		data []byte
+	}{
+		{flv.TypeVideo, 1000, append([]byte{0x17, 0, 0, 0, 0}, vc.Bytes()...)},
+		{flv.TypeAudio, 1000, aac.AppendSequenceHeader(nil, ac)},
+		{flv.TypeVideo, 1000, []byte{0x17, 1, 0, 0, 40, 0, 0, 0, 2, 0x65, 0x88}},
+		{flv.TypeAudio, 1000, aac.AppendFrame(nil, []byte{0x21, 0x10})},
+		{flv.TypeAudio, 1023, aac.AppendFrame(nil, []byte{0x21, 0x11})},
+		{flv.TypeVideo, 1040, []byte{0x27, 1, 0, 0, 40, 0, 0, 0, 2, 0x41, 0x9a}},
+		{flv.TypeAudio, 1046, aac.AppendFrame(nil, []byte{0x21, 0x12})},
+		{flv.TypeVideo, 1080, []byte{0x17, 1, 0, 0, 40, 0, 0, 0, 2, 0x65, 0x88}},
+		{flv.TypeAudio, 1070, aac.AppendFrame(nil, []byte{0x21, 0x13})},
+		{flv.TypeVideo, 1120, []byte{0x27, 1, 0, 0, 40, 0, 0, 0, 2, 0x41, 0x9a}},
+	}
+	for _, it := range tags {
+		if err := w.WriteTag(&flv.Tag{Type: it.typ, Time: it.time}, it.data); err != nil {
+			t.Fatal("write tag:", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal("close:", err)
+	}
+}
+
+func TestProgressive(t *testing.T) {
+	f := &testFile{}
+	writeTestTags(t, NewWriter(f))
+	boxes := walkBoxes(t, f.b, "")
+	for _, it := range []string{"/ftyp", "/mdat", "/moov", "/moov/trak/edts", "/moov/trak/mdia/minf/stbl"} {
+		if !contains(boxes, it) {
+			t.Fatalf("progressive: no %s in %v", it, boxes)
+		}
+	}
+	if !bytes.Contains(f.b, []byte("avcC")) || !bytes.Contains(f.b, []byte("esds")) || !bytes.Contains(f.b, []byte("ctts")) {
+		t.Fatal("progressive: no sample entries")
+	}
+}
+
+func TestFragmented(t *testing.T) {
+	b := &bytes.Buffer{}
+	writeTestTags(t, NewFragmentWriter(b))
+	boxes := walkBoxes(t, b.Bytes(), "")
+	n := 0
+	for _, it := range boxes {
+		if it == "/moof" {
+			n++
+		}
+	}
+	if n != 2 || !contains(boxes, "/moov/mvex") || !contains(boxes, "/moof/traf") {
+		t.Fatalf("fragmented: %v", boxes)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, it := range list {
+		if it == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,452 @@
+package mp4
+
+import (
+	"encoding/binary"
+
+	"github.com/pixelbender/go-rtmp/codec/aac"
+	"github.com/pixelbender/go-rtmp/codec/h264"
+	"github.com/pixelbender/go-rtmp/codec/hevc"
+	"github.com/pixelbender/go-rtmp/flv"
+)
+
+type sample struct {
+	time   int64
+	dur    uint32
+	cto    int32
+	size   uint32
+	sync   bool
+	offset int64
+	data   []byte
+}
+
+type track struct {
+	id        uint32
+	video     bool
+	codec     uint32
+	timescale uint32
+	config    []byte
+	width     int
+	height    int
+	channels  int
+	rate      int
+	frameDur  uint32
+
+	samples []*sample
+	pending *sample
+}
+
+func newVideoTrack(id uint32, codec uint32, config []byte) (*track, error) {
+	t := &track{id: id, video: true, codec: codec, timescale: 1000, config: config}
+	switch codec {
+	case flv.FourCCAVC:
+		c, err := h264.ParseDecoderConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		if len(c.SPS) > 0 {
+			if s, err := h264.ParseSPS(c.SPS[0]); err == nil {
+				t.width, t.height = s.Width, s.Height
+			}
+		}
+	case flv.FourCCHEVC:
+		c, err := hevc.ParseDecoderConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		if sps := c.SPS(); len(sps) > 0 {
+			if s, err := hevc.ParseSPS(sps[0]); err == nil {
+				t.width, t.height = s.Width, s.Height
+			}
+		}
+	default:
+		return nil, ErrCodec
+	}
+	return t, nil
+}
+
+func newAudioTrack(id uint32, codec uint32, config []byte) (*track, error) {
+	t := &track{id: id, codec: codec, config: config}
+	switch codec {
+	case flv.FourCCAAC:
+		c, err := aac.ParseConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		t.channels, t.rate, t.frameDur = c.ChannelCount(), c.SampleRate, uint32(c.FrameLength)
+	case flv.FourCCOpus:
+		if len(config) < 19 || string(config[:8]) != "OpusHead" {
+			return nil, ErrCodec
+		}
+		t.channels, t.rate, t.frameDur = int(config[9]), 48000, 960
+	default:
+		return nil, ErrCodec
+	}
+	t.timescale = uint32(t.rate)
+	return t, nil
+}
+
+// add appends a sample at time in milliseconds and completes the previous one.
+func (t *track) add(s *sample, ms int64) {
+	s.time = ms * int64(t.timescale) / 1000
+	if p := t.pending; p != nil {
+		if s.time <= p.time {
+			s.time = p.time + 1
+		}
+		p.dur = uint32(s.time - p.time)
+	}
+	t.samples = append(t.samples, s)
+	t.pending = s
+}
+
+// completed returns the number of samples with known duration.
+func (t *track) completed() int {
+	if t.pending != nil {
+		return len(t.samples) - 1
+	}
+	return len(t.samples)
+}
+
+// finish sets the duration of the last sample.
+func (t *track) finish() {
+	p := t.pending
+	if p == nil {
+		return
+	}
+	if n := len(t.samples); n > 1 {
+		p.dur = t.samples[n-2].dur
+	} else if t.video {
+		p.dur = 1
+	} else {
+		p.dur = t.frameDur
+	}
+	t.pending = nil
+}
+
+func (t *track) duration() (d int64) {
+	for _, s := range t.samples {
+		d += int64(s.dur)
+	}
+	return
+}
+
+func (t *track) writeTrack(b *buffer, fragmented bool) {
+	trak := b.start("trak")
+	d := uint64(0)
+	if !fragmented {
+		d = uint64(t.duration())
+	}
+	tkhd := b.startFull("tkhd", 0, 0x03)
+	b.u32(0)
+	b.u32(0)
+	b.u32(t.id)
+	b.u32(0)
+	b.u32(uint32(d * 1000 / uint64(t.timescale)))
+	b.zeros(8)
+	b.u16(0)
+	b.u16(0)
+	if t.video {
+		b.u16(0)
+	} else {
+		b.u16(0x0100)
+	}
+	b.u16(0)
+	b.matrix()
+	b.u32(uint32(t.width) << 16)
+	b.u32(uint32(t.height) << 16)
+	b.end(tkhd)
+	if !fragmented && t.video && len(t.samples) > 0 && t.samples[0].cto > 0 {
+		edts := b.start("edts")
+		elst := b.startFull("elst", 0, 0)
+		b.u32(1)
+		b.u32(uint32(d * 1000 / uint64(t.timescale)))
+		b.u32(uint32(t.samples[0].cto))
+		b.u32(0x00010000)
+		b.end(elst)
+		b.end(edts)
+	}
+	mdia := b.start("mdia")
+	mdhd := b.startFull("mdhd", 0, 0)
+	b.u32(0)
+	b.u32(0)
+	b.u32(t.timescale)
+	b.u32(uint32(d))
+	b.u16(0x55c4) // und
+	b.u16(0)
+	b.end(mdhd)
+	hdlr := b.startFull("hdlr", 0, 0)
+	b.u32(0)
+	if t.video {
+		b.str("vide")
+		b.zeros(12)
+		b.str("VideoHandler\x00")
+	} else {
+		b.str("soun")
+		b.zeros(12)
+		b.str("SoundHandler\x00")
+	}
+	b.end(hdlr)
+	minf := b.start("minf")
+	if t.video {
+		vmhd := b.startFull("vmhd", 0, 0x01)
+		b.zeros(8)
+		b.end(vmhd)
+	} else {
+		smhd := b.startFull("smhd", 0, 0)
+		b.zeros(4)
+		b.end(smhd)
+	}
+	dinf := b.start("dinf")
+	dref := b.startFull("dref", 0, 0)
+	b.u32(1)
+	url := b.startFull("url ", 0, 0x01)
+	b.end(url)
+	b.end(dref)
+	b.end(dinf)
+	stbl := b.start("stbl")
+	t.writeSampleDescription(b)
+	if fragmented {
+		for _, typ := range []string{"stts", "stsc", "stsz", "stco"} {
+			off := b.startFull(typ, 0, 0)
+			if typ == "stsz" {
+				b.u32(0)
+			}
+			b.u32(0)
+			b.end(off)
+		}
+	} else {
+		t.writeSampleTables(b)
+	}
+	b.end(stbl)
+	b.end(minf)
+	b.end(mdia)
+	b.end(trak)
+}
+
+func (t *track) writeSampleDescription(b *buffer) {
+	stsd := b.startFull("stsd", 0, 0)
+	b.u32(1)
+	switch t.codec {
+	case flv.FourCCAVC:
+		t.writeVisualEntry(b, "avc1", "avcC")
+	case flv.FourCCHEVC:
+		t.writeVisualEntry(b, "hvc1", "hvcC")
+	case flv.FourCCAAC:
+		mp4a := t.writeAudioEntry(b, "mp4a")
+		esds := b.startFull("esds", 0, 0)
+		es := b.descriptor(0x03)
+		b.u16(0)
+		b.u8(0)
+		dc := b.descriptor(0x04)
+		b.u8(0x40)
+		b.u8(0x15)
+		b.u24(0)
+		b.u32(0)
+		b.u32(0)
+		dsi := b.descriptor(0x05)
+		b.bytes(t.config)
+		b.endDescriptor(dsi)
+		b.endDescriptor(dc)
+		sl := b.descriptor(0x06)
+		b.u8(0x02)
+		b.endDescriptor(sl)
+		b.endDescriptor(es)
+		b.end(esds)
+		b.end(mp4a)
+	case flv.FourCCOpus:
+		opus := t.writeAudioEntry(b, "Opus")
+		dops := b.start("dOps")
+		h := t.config
+		b.u8(0)
+		b.u8(h[9])
+		b.u16(binary.LittleEndian.Uint16(h[10:]))
+		b.u32(binary.LittleEndian.Uint32(h[12:]))
+		b.u16(binary.LittleEndian.Uint16(h[16:]))
+		b.bytes(h[18:])
+		b.end(dops)
+		b.end(opus)
+	}
+	b.end(stsd)
+}
+
+func (t *track) writeVisualEntry(b *buffer, typ, config string) {
+	entry := b.start(typ)
+	b.zeros(6)
+	b.u16(1)
+	b.zeros(16)
+	b.u16(uint16(t.width))
+	b.u16(uint16(t.height))
+	b.u32(0x00480000)
+	b.u32(0x00480000)
+	b.u32(0)
+	b.u16(1)
+	b.zeros(32)
+	b.u16(0x0018)
+	b.u16(0xffff)
+	c := b.start(config)
+	b.bytes(t.config)
+	b.end(c)
+	b.end(entry)
+}
+
+func (t *track) writeAudioEntry(b *buffer, typ string) int {
+	entry := b.start(typ)
+	b.zeros(6)
+	b.u16(1)
+	b.zeros(8)
+	b.u16(uint16(t.channels))
+	b.u16(16)
+	b.u16(0)
+	b.u16(0)
+	if t.rate < 0x10000 {
+		b.u32(uint32(t.rate) << 16)
+	} else {
+		b.u32(0)
+	}
+	return entry
+}
+
+func (t *track) writeSampleTables(b *buffer) {
+	stts := b.startFull("stts", 0, 0)
+	n := b.reserve()
+	count := 0
+	for i := 0; i < len(t.samples); {
+		j := i + 1
+		for j < len(t.samples) && t.samples[j].dur == t.samples[i].dur {
+			j++
+		}
+		b.u32(uint32(j - i))
+		b.u32(t.samples[i].dur)
+		count, i = count+1, j
+	}
+	be.PutUint32(b.b[n:], uint32(count))
+	b.end(stts)
+
+	cts, neg := false, false
+	for _, s := range t.samples {
+		cts, neg = cts || s.cto != 0, neg || s.cto < 0
+	}
+	if cts {
+		version := uint8(0)
+		if neg {
+			version = 1
+		}
+		ctts := b.startFull("ctts", version, 0)
+		n, count = b.reserve(), 0
+		for i := 0; i < len(t.samples); {
+			j := i + 1
+			for j < len(t.samples) && t.samples[j].cto == t.samples[i].cto {
+				j++
+			}
+			b.u32(uint32(j - i))
+			b.u32(uint32(t.samples[i].cto))
+			count, i = count+1, j
+		}
+		be.PutUint32(b.b[n:], uint32(count))
+		b.end(ctts)
+	}
+
+	if t.video {
+		stss := b.startFull("stss", 0, 0)
+		n, count = b.reserve(), 0
+		for i, s := range t.samples {
+			if s.sync {
+				b.u32(uint32(i + 1))
+				count++
+			}
+		}
+		be.PutUint32(b.b[n:], uint32(count))
+		b.end(stss)
+	}
+
+	var chunks []int64
+	var sizes []int
+	for i, s := range t.samples {
+		if i > 0 {
+			if p := t.samples[i-1]; p.offset+int64(p.size) == s.offset {
+				sizes[len(sizes)-1]++
+				continue
+			}
+		}
+		chunks, sizes = append(chunks, s.offset), append(sizes, 1)
+	}
+	stsc := b.startFull("stsc", 0, 0)
+	n, count = b.reserve(), 0
+	for i := range sizes {
+		if i > 0 && sizes[i] == sizes[i-1] {
+			continue
+		}
+		b.u32(uint32(i + 1))
+		b.u32(uint32(sizes[i]))
+		b.u32(1)
+		count++
+	}
+	be.PutUint32(b.b[n:], uint32(count))
+	b.end(stsc)
+
+	stsz := b.startFull("stsz", 0, 0)
+	b.u32(0)
+	b.u32(uint32(len(t.samples)))
+	for _, s := range t.samples {
+		b.u32(s.size)
+	}
+	b.end(stsz)
+
+	large := len(chunks) > 0 && chunks[len(chunks)-1] > 0xffffffff
+	if large {
+		co64 := b.startFull("co64", 0, 0)
+		b.u32(uint32(len(chunks)))
+		for _, it := range chunks {
+			b.u64(uint64(it))
+		}
+		b.end(co64)
+	} else {
+		stco := b.startFull("stco", 0, 0)
+		b.u32(uint32(len(chunks)))
+		for _, it := range chunks {
+			b.u32(uint32(it))
+		}
+		b.end(stco)
+	}
+}
+
+func (t *track) writeTrackExtends(b *buffer) {
+	trex := b.startFull("trex", 0, 0)
+	b.u32(t.id)
+	b.u32(1)
+	b.u32(0)
+	b.u32(0)
+	b.u32(0)
+	b.end(trex)
+}
+
+const (
+	sampleSync    = uint32(0x02000000)
+	sampleNonSync = uint32(0x01010000)
+)
+
+// writeFragment writes traf of completed samples and returns the offset of trun data_offset field.
+func (t *track) writeFragment(b *buffer, n int) int {
+	traf := b.start("traf")
+	tfhd := b.startFull("tfhd", 0, 0x020000)
+	b.u32(t.id)
+	b.end(tfhd)
+	tfdt := b.startFull("tfdt", 1, 0)
+	b.u64(uint64(t.samples[0].time))
+	b.end(tfdt)
+	trun := b.startFull("trun", 1, 0x000f01)
+	b.u32(uint32(n))
+	off := b.reserve()
+	for _, s := range t.samples[:n] {
+		b.u32(s.dur)
+		b.u32(s.size)
+		if s.sync || !t.video {
+			b.u32(sampleSync)
+		} else {
+			b.u32(sampleNonSync)
+		}
+		b.u32(uint32(s.cto))
+	}
+	b.end(trun)
+	b.end(traf)
+	return off
+}
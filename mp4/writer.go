@@ -0,0 +1,283 @@
+// Package mp4 implements remuxing of FLV and RTMP media into progressive and fragmented MP4.
+package mp4
+
+import (
+	"bufio"
+	"errors"
+	"io"
+
+	"github.com/pixelbender/go-rtmp/flv"
+)
+
+// ErrCodec is returned when a sequence header describes an unsupported codec.
+var ErrCodec = errors.New("mp4: unsupported codec")
+
+// ErrNotSeekable is returned by Close of progressive writer when the output is not seekable.
+var ErrNotSeekable = errors.New("mp4: output is not seekable")
+
+// DefaultFragmentDuration is the duration of fragments in milliseconds for streams without video.
+var DefaultFragmentDuration = int64(2000)
+
+// Writer writes FLV tags as MP4 tracks.
+// Tracks are created from AVC, HEVC, AAC and Opus sequence headers received before the first frame.
+type Writer struct {
+	w          io.Writer
+	buf        *bufio.Writer
+	fragmented bool
+
+	video *track
+	audio *track
+
+	started bool
+	base    int64
+	pos     int64
+	mdat    int64
+	seq     uint32
+
+	// FragmentDuration is the duration of fragments in milliseconds for streams without video.
+	FragmentDuration int64
+}
+
+// NewWriter returns a writer of progressive MP4 with samples in a single mdat and moov at the end.
+// The output must implement io.Seeker to update the mdat size on Close.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w, buf: bufio.NewWriter(w)}
+}
+
+// NewFragmentWriter returns a writer of fragmented MP4 with the init segment followed by a fragment per GOP.
+func NewFragmentWriter(w io.Writer) *Writer {
+	return &Writer{w: w, buf: bufio.NewWriter(w), fragmented: true, FragmentDuration: DefaultFragmentDuration}
+}
+
+// WriteTag writes FLV tag with the given data.
+func (w *Writer) WriteTag(tag *flv.Tag, data []byte) error {
+	switch tag.Type {
+	case flv.TypeVideo:
+		h, n, err := flv.ParseVideoHeader(data)
+		if err != nil {
+			return err
+		}
+		switch {
+		case h.IsSequenceHeader():
+			if w.video == nil && !w.started {
+				t, err := newVideoTrack(w.nextTrackID(), h.FourCC, copyBytes(data[n:]))
+				if err != nil {
+					return err
+				}
+				w.video = t
+			}
+		case h.IsCodedFrames() && w.video != nil && w.video.codec == h.FourCC:
+			return w.writeSample(w.video, tag.Time, &sample{
+				cto:  h.CompositionTime,
+				sync: h.IsKeyFrame(),
+				data: data[n:],
+			})
+		}
+	case flv.TypeAudio:
+		h, n, err := flv.ParseAudioHeader(data)
+		if err != nil {
+			return err
+		}
+		switch {
+		case h.IsSequenceHeader():
+			if w.audio == nil && !w.started {
+				t, err := newAudioTrack(w.nextTrackID(), h.FourCC, copyBytes(data[n:]))
+				if err != nil {
+					return err
+				}
+				w.audio = t
+			}
+		case h.IsCodedFrames() && w.audio != nil && w.audio.codec == h.FourCC:
+			return w.writeSample(w.audio, tag.Time, &sample{
+				sync: true,
+				data: data[n:],
+			})
+		}
+	}
+	return nil
+}
+
+func (w *Writer) nextTrackID() uint32 {
+	if w.video != nil || w.audio != nil {
+		return 2
+	}
+	return 1
+}
+
+func (w *Writer) tracks() (r []*track) {
+	if w.video != nil {
+		r = append(r, w.video)
+	}
+	if w.audio != nil {
+		r = append(r, w.audio)
+	}
+	return
+}
+
+func (w *Writer) writeSample(t *track, ms int64, s *sample) (err error) {
+	if !w.started {
+		if err = w.start(ms); err != nil {
+			return
+		}
+	}
+	s.size = uint32(len(s.data))
+	t.add(s, ms-w.base)
+	if !w.fragmented {
+		_, err = w.buf.Write(s.data)
+		s.offset, s.data = w.pos, nil
+		w.pos += int64(s.size)
+		return
+	}
+	s.data = copyBytes(s.data)
+	if w.video != nil {
+		if t == w.video && s.sync && t.completed() > 0 {
+			return w.writeFragment()
+		}
+		return
+	}
+	if n := t.completed(); n > 0 && t.samples[n].time-t.samples[0].time >= w.FragmentDuration*int64(t.timescale)/1000 {
+		return w.writeFragment()
+	}
+	return
+}
+
+func (w *Writer) start(ms int64) error {
+	w.started, w.base = true, ms
+	b := &buffer{}
+	ftyp := b.start("ftyp")
+	b.str("isom")
+	b.u32(0x200)
+	if w.fragmented {
+		b.str("isomiso6mp41")
+	} else {
+		b.str("isomiso2mp41")
+	}
+	b.end(ftyp)
+	if w.fragmented {
+		w.writeMovie(b)
+	} else {
+		b.u32(1)
+		b.str("mdat")
+		w.mdat = int64(len(b.b))
+		b.u64(0)
+	}
+	w.pos = int64(len(b.b))
+	_, err := w.buf.Write(b.b)
+	return err
+}
+
+func (w *Writer) writeMovie(b *buffer) {
+	tracks := w.tracks()
+	moov := b.start("moov")
+	mvhd := b.startFull("mvhd", 0, 0)
+	b.u32(0)
+	b.u32(0)
+	b.u32(1000)
+	d := uint32(0)
+	if !w.fragmented {
+		for _, t := range tracks {
+			if v := uint32(t.duration() * 1000 / int64(t.timescale)); v > d {
+				d = v
+			}
+		}
+	}
+	b.u32(d)
+	b.u32(0x00010000)
+	b.u16(0x0100)
+	b.zeros(10)
+	b.matrix()
+	b.zeros(24)
+	b.u32(uint32(len(tracks) + 1))
+	b.end(mvhd)
+	for _, t := range tracks {
+		t.writeTrack(b, w.fragmented)
+	}
+	if w.fragmented {
+		mvex := b.start("mvex")
+		for _, t := range tracks {
+			t.writeTrackExtends(b)
+		}
+		b.end(mvex)
+	}
+	b.end(moov)
+}
+
+func (w *Writer) writeFragment() error {
+	w.seq++
+	b := &buffer{}
+	moof := b.start("moof")
+	mfhd := b.startFull("mfhd", 0, 0)
+	b.u32(w.seq)
+	b.end(mfhd)
+	type run struct {
+		t   *track
+		n   int
+		off int
+	}
+	var runs []run
+	for _, t := range w.tracks() {
+		if n := t.completed(); n > 0 {
+			runs = append(runs, run{t, n, t.writeFragment(b, n)})
+		}
+	}
+	b.end(moof)
+	if len(runs) == 0 {
+		w.seq--
+		return nil
+	}
+	mdat := b.start("mdat")
+	for _, r := range runs {
+		be.PutUint32(b.b[r.off:], uint32(len(b.b)))
+		for _, s := range r.t.samples[:r.n] {
+			b.bytes(s.data)
+		}
+		r.t.samples = append(r.t.samples[:0], r.t.samples[r.n:]...)
+	}
+	b.end(mdat)
+	_, err := w.buf.Write(b.b)
+	return err
+}
+
+// Close writes the last fragment or the movie box and flushes the output.
+func (w *Writer) Close() error {
+	if !w.started {
+		return w.buf.Flush()
+	}
+	for _, t := range w.tracks() {
+		t.finish()
+	}
+	if w.fragmented {
+		if err := w.writeFragment(); err != nil {
+			return err
+		}
+		return w.buf.Flush()
+	}
+	b := &buffer{}
+	w.writeMovie(b)
+	if _, err := w.buf.Write(b.b); err != nil {
+		return err
+	}
+	if err := w.buf.Flush(); err != nil {
+		return err
+	}
+	s, ok := w.w.(io.Seeker)
+	if !ok {
+		return ErrNotSeekable
+	}
+	if _, err := s.Seek(w.mdat, io.SeekStart); err != nil {
+		return err
+	}
+	b.b = b.b[:0]
+	b.u64(uint64(w.pos - w.mdat + 8))
+	if _, err := w.w.Write(b.b); err != nil {
+		return err
+	}
+	_, err := s.Seek(0, io.SeekEnd)
+	return err
+}
+
+func copyBytes(b []byte) []byte {
+	r := make([]byte, len(b))
+	copy(r, b)
+	return r
+}
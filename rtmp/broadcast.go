@@ -0,0 +1,171 @@
+package rtmp
+
+import (
+	"sync"
+
+	"github.com/pixelbender/go-rtmp/flv"
+)
+
+// playerQueueSize limits the number of messages queued for a player.
+// Messages for a player falling behind the publisher by more messages are dropped,
+// so it does not stall the publisher and other players.
+const playerQueueSize = 256
+
+// broadcast routes media of the published stream to players of the same stream name.
+// Metadata and sequence headers are cached for players joining after the publisher.
+type broadcast struct {
+	key  string
+	refs int // guarded by Server.mu
+
+	mu        sync.Mutex
+	publisher *Stream
+	players   map[*Stream]*player
+	meta      []byte
+	video     []byte
+	audio     []byte
+}
+
+func (b *broadcast) write(typ uint8, ts int64, data []byte) {
+	// The data is shared by queues of players, so it must not be reused by the reader
+	data = append([]byte(nil), data...)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch typ {
+	case msgAmf0Meta:
+		b.meta = data
+	case msgVideo:
+		if h, _, err := flv.ParseVideoHeader(data); err == nil && h.IsSequenceHeader() {
+			b.video = data
+		}
+	case msgAudio:
+		if h, _, err := flv.ParseAudioHeader(data); err == nil && h.IsSequenceHeader() {
+			b.audio = data
+		}
+	}
+	for _, p := range b.players {
+		b.send(p, &playerMessage{typ: typ, time: ts, data: data})
+	}
+}
+
+func (b *broadcast) publish(s *Stream) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.publisher != nil {
+		return false
+	}
+	b.publisher = s
+	for _, p := range b.players {
+		b.send(p, &playerMessage{status: NewStatus(NetStreamPlayPublishNotify, s.name+" is now published.")})
+	}
+	return true
+}
+
+func (b *broadcast) unpublish(s *Stream) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.publisher != s {
+		return
+	}
+	b.publisher, b.meta, b.video, b.audio = nil, nil, nil, nil
+	for _, p := range b.players {
+		b.send(p, &playerMessage{status: NewStatus(NetStreamPlayUnpublishNotify, s.name+" is now unpublished.")})
+	}
+}
+
+func (b *broadcast) play(s *Stream) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.players == nil {
+		b.players = make(map[*Stream]*player)
+	}
+	p := &player{
+		stream: s,
+		queue:  make(chan *playerMessage, playerQueueSize),
+		stop:   make(chan struct{}),
+		slow:   make(chan struct{}, 1),
+	}
+	b.players[s] = p
+	go p.run()
+	if b.meta != nil {
+		b.send(p, &playerMessage{typ: msgAmf0Meta, data: b.meta})
+	}
+	if b.video != nil {
+		b.send(p, &playerMessage{typ: msgVideo, data: b.video})
+	}
+	if b.audio != nil {
+		b.send(p, &playerMessage{typ: msgAudio, data: b.audio})
+	}
+}
+
+func (b *broadcast) stop(s *Stream) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if p, ok := b.players[s]; ok {
+		delete(b.players, s)
+		close(p.stop)
+	}
+}
+
+// send queues the message for the player. If the queue is full, the message is dropped
+// and video is skipped up to the next key frame, the player is notified by NetStream.Play.InsufficientBW.
+func (b *broadcast) send(p *player, m *playerMessage) {
+	if p.skip && m.typ == msgVideo && !isKeyFrame(m.typ, m.data) {
+		return
+	}
+	select {
+	case p.queue <- m:
+		if m.typ == msgVideo {
+			p.skip = false
+		}
+	default:
+		if !p.skip {
+			p.skip = true
+			select {
+			case p.slow <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// player writes messages queued by the broadcast to the player stream.
+// Writing is done by its own goroutine, which may wait for acknowledgement of the peer.
+type player struct {
+	stream *Stream
+	queue  chan *playerMessage
+	stop   chan struct{}
+	slow   chan struct{}
+	skip   bool // guarded by broadcast.mu
+}
+
+type playerMessage struct {
+	typ    uint8
+	time   int64
+	data   []byte
+	status *Status
+}
+
+func (p *player) run() {
+	s := p.stream
+	for {
+		select {
+		case m := <-p.queue:
+			var err error
+			if m.status != nil {
+				err = s.SendStatus(m.status)
+			} else {
+				err = s.writeMedia(m.typ, m.time, m.data)
+			}
+			if err != nil {
+				return
+			}
+		case <-p.slow:
+			st := &Status{Level: LevelWarning, Code: NetStreamPlayInsufficientBW, Description: "Skipping frames of " + s.name + "."}
+			if s.SendStatus(st) != nil {
+				return
+			}
+		case <-p.stop:
+			return
+		}
+	}
+}
@@ -25,20 +25,35 @@ type chunk struct {
 }
 
 type reader struct {
-	buf  *bufio.Reader
-	mux  map[uint32]*chunkReader
-	skip int
-	size int
+	buf    *bufio.Reader
+	in     *countReader
+	mux    map[uint32]*chunkReader
+	skip   int
+	size   int
+	ack    uint32 // sequence number of the last acknowledgement
+	window uint32 // acknowledgement window of the peer, zero if not set
 }
 
 func newReader(r io.Reader) *reader {
+	in := &countReader{r: r}
 	return &reader{
-		buf:  bufio.NewReaderSize(r, bufferSize),
+		buf:  bufio.NewReaderSize(in, bufferSize),
+		in:   in,
 		mux:  make(map[uint32]*chunkReader),
 		size: 128,
 	}
 }
 
+// acknowledge returns the sequence number to acknowledge if the number of bytes received
+// since the last acknowledgement reached the window size of the peer.
+func (r *reader) acknowledge() (uint32, bool) {
+	if r.window == 0 || r.in.n-r.ack < r.window {
+		return 0, false
+	}
+	r.ack = r.in.n
+	return r.ack, true
+}
+
 func (r *reader) ReadChunk() (ch *chunk, err error) {
 	var fmt uint8
 	var id uint32
@@ -59,6 +74,13 @@ func (r *reader) ReadChunk() (ch *chunk, err error) {
 	}
 }
 
+// abort discards the partially received message of the chunk stream.
+func (r *reader) abort(id uint32) {
+	if cr, ok := r.mux[id]; ok {
+		cr.pos, cr.Data = 0, nil
+	}
+}
+
 func (r *reader) Peek(n int) ([]byte, error) {
 	r.discard()
 	r.skip = n
@@ -67,7 +89,7 @@ func (r *reader) Peek(n int) ([]byte, error) {
 
 func (r *reader) Read(b []byte) (int, error) {
 	r.discard()
-	return r.buf.Read(b)
+	return io.ReadFull(r.buf, b)
 }
 
 func (r *reader) discard() {
@@ -98,11 +120,25 @@ func (r *reader) readHeader() (fmt uint8, id uint32, err error) {
 	return
 }
 
+// countReader counts bytes received, the counter wraps at 32 bits as the sequence number of acknowledgements.
+type countReader struct {
+	r io.Reader
+	n uint32
+}
+
+func (r *countReader) Read(b []byte) (n int, err error) {
+	n, err = r.r.Read(b)
+	r.n += uint32(n)
+	return
+}
+
 type chunkReader struct {
 	chunk
-	buf []byte
-	pos int
-	len int
+	buf   []byte
+	pos   int
+	len   int
+	delta int64
+	ext   bool
 }
 
 func (cr *chunkReader) Reset(n int) {
@@ -120,6 +156,14 @@ func (cr *chunkReader) Read(r *reader, fmt uint8) (ch *chunk, err error) {
 		err = cr.readStreamHeader(r)
 	case fmtDelta:
 		err = cr.readDeltaHeader(r)
+	case fmtData:
+		if cr.ext {
+			cr.skipExtendedTime(r)
+		}
+		if cr.pos == 0 {
+			// New message with the header of the previous one
+			cr.Time += cr.delta
+		}
 	}
 	if err != nil {
 		return
@@ -155,16 +199,17 @@ func (cr *chunkReader) readFullHeader(r *reader) (err error) {
 	if b, err = r.Peek(11); err != nil {
 		return
 	}
-	cr.Time = int64(getUint24(b))
+	ts := int64(getUint24(b))
 	cr.Reset(int(getUint24(b[3:])))
 	cr.Type = b[6]
 	cr.Stream = le.Uint32(b[7:])
-	if cr.Time == timeOverflow {
+	if cr.ext = ts == timeOverflow; cr.ext {
 		if b, err = r.Peek(4); err != nil {
 			return
 		}
-		cr.Time = int64(be.Uint32(b))
+		ts = int64(be.Uint32(b))
 	}
+	cr.Time, cr.delta = extendTime(cr.Time, ts), ts
 	return
 }
 
@@ -176,13 +221,13 @@ func (cr *chunkReader) readStreamHeader(r *reader) (err error) {
 	dt := int64(getUint24(b))
 	cr.Reset(int(getUint24(b[3:])))
 	cr.Type = b[6]
-	if dt == timeOverflow {
+	if cr.ext = dt == timeOverflow; cr.ext {
 		if b, err = r.Peek(4); err != nil {
 			return
 		}
 		dt = int64(be.Uint32(b))
 	}
-	cr.Time += dt
+	cr.Time, cr.delta = cr.Time+dt, dt
 	return
 }
 
@@ -193,30 +238,130 @@ func (cr *chunkReader) readDeltaHeader(r *reader) (err error) {
 	}
 	dt := int64(getUint24(b))
 	cr.pos = 0
-	if dt == timeOverflow {
+	if cr.ext = dt == timeOverflow; cr.ext {
 		if b, err = r.Peek(4); err != nil {
 			return
 		}
 		dt = int64(be.Uint32(b))
 	}
-	cr.Time += dt
+	cr.Time, cr.delta = cr.Time+dt, dt
 	return
 }
 
+// skipExtendedTime skips the extended timestamp repeated in type 3 chunk.
+// Some peers do not repeat it, so it is skipped only if it matches the one of the message header.
+// The reader does not wait for the timestamp if the rest of the message is shorter than it.
+func (cr *chunkReader) skipExtendedTime(r *reader) {
+	r.discard()
+	if cr.len-cr.pos < 4 && r.buf.Buffered() < 4 {
+		return
+	}
+	if b, _ := r.buf.Peek(4); len(b) == 4 && int64(be.Uint32(b)) == cr.delta {
+		r.skip = 4
+	}
+}
+
+// extendTime extends the 32-bit timestamp to the value closest to the previous timestamp.
+func extendTime(prev, ts int64) int64 {
+	ts |= prev &^ 0xffffffff
+	switch {
+	case ts < prev-0x80000000:
+		ts += 0x100000000
+	case ts > prev+0x80000000 && ts >= 0x100000000:
+		ts -= 0x100000000
+	}
+	return ts
+}
+
+// chunkHeader is the state of the last message header of the chunk stream.
+type chunkHeader struct {
+	time   int64
+	delta  int64
+	len    int
+	typ    uint8
+	stream uint32
+}
+
 type writer struct {
 	mu   sync.Mutex
 	w    io.Writer
 	buf  []byte
 	pos  int
-	ack  int
 	size int
+	hdr  map[uint32]*chunkHeader
+
+	cond   *sync.Cond
+	sent   uint32 // number of bytes sent
+	acked  uint32 // sequence number of the last acknowledgement received
+	window uint32 // peer bandwidth, zero if not limited
+	limit  uint8  // limit type of the peer bandwidth
+	closed bool
 }
 
 func newWriter(w io.Writer) *writer {
-	return &writer{
-		w:    w,
-		size: 128,
+	wr := &writer{
+		w:     w,
+		size:  128,
+		hdr:   make(map[uint32]*chunkHeader),
+		limit: limitDynamic,
 	}
+	wr.cond = sync.NewCond(&wr.mu)
+	return wr
+}
+
+// setBandwidth applies Set Peer Bandwidth message with the limit type.
+// Soft limit keeps the smaller window, dynamic limit is applied only if the previous limit was hard.
+// It returns the window in effect and false if the message is ignored.
+func (w *writer) setBandwidth(v uint32, limit uint8) (uint32, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	switch limit {
+	case limitHard:
+	case limitSoft:
+		if w.window != 0 && w.window < v {
+			v = w.window
+		}
+	case limitDynamic:
+		if w.limit != limitHard {
+			return w.window, false
+		}
+		limit = limitHard
+	default:
+		return w.window, false
+	}
+	w.window, w.limit = v, limit
+	w.cond.Broadcast()
+	return v, true
+}
+
+// acknowledge handles Acknowledgement message with the sequence number of bytes received by the peer.
+func (w *writer) acknowledge(seq uint32) {
+	w.mu.Lock()
+	w.acked = seq
+	w.cond.Broadcast()
+	w.mu.Unlock()
+}
+
+// wait blocks while the number of unacknowledged bytes exceeds the peer bandwidth.
+// It returns false if the writer is closed.
+func (w *writer) wait() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Peers may count handshake bytes, so the sequence number is compared as signed difference
+	for !w.closed && w.window != 0 && int32(w.sent-w.acked) >= int32(w.window) {
+		w.cond.Wait()
+	}
+	return !w.closed
+}
+
+// close wakes up writers waiting for acknowledgement.
+func (w *writer) close() {
+	w.mu.Lock()
+	w.closed = true
+	w.cond.Broadcast()
+	w.mu.Unlock()
 }
 
 func (w *writer) next(n int) (b []byte) {
@@ -239,19 +384,61 @@ func (w *writer) grow(n int) {
 	}
 }
 
-func (w *writer) WriteFull(id uint32, ts int64, ct uint8, str uint32, data []byte) {
+// WriteMessage writes the message choosing the most compact chunk header
+// from the state of the previous message of the chunk stream.
+func (w *writer) WriteMessage(id uint32, ts int64, ct uint8, str uint32, data []byte) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writeMessage(id, ts, ct, str, data)
+}
+
+// SetChunkSize writes Set Chunk Size message and switches to the chunk size n
+// before the next message is written.
+func (w *writer) SetChunkSize(n int) {
 	w.mu.Lock()
 	defer w.mu.Unlock()
+	b := make([]byte, 4)
+	be.PutUint32(b, uint32(n))
+	w.writeMessage(chunkControl, 0, msgSetChunkSize, 0, b)
+	w.size = n
+}
 
+func (w *writer) writeMessage(id uint32, ts int64, ct uint8, str uint32, data []byte) {
 	n := len(data)
-	w.grow(n + 18 + 3*int(n/w.size))
-	w.writeHeader(fmtFull, id)
-	w.writeFullHeader(ts, ct, str, n)
+	w.grow(n + 18 + 7*int(n/w.size))
+	h := w.hdr[id]
+	var dt, delta int64
+	switch {
+	case h == nil || h.stream != str || ts < h.time:
+		if h == nil {
+			h = &chunkHeader{}
+			w.hdr[id] = h
+		}
+		// Peers disagree on the delta of type 3 message following type 0 header
+		dt, delta = ts, -1
+		w.writeHeader(fmtFull, id)
+		w.writeFullHeader(ts, ct, str, n)
+	case h.typ != ct || h.len != n:
+		dt = ts - h.time
+		w.writeHeader(fmtStream, id)
+		w.writeStreamHeader(dt, ct, n)
+	case ts-h.time != h.delta:
+		dt = ts - h.time
+		w.writeHeader(fmtDelta, id)
+		w.writeDeltaHeader(dt)
+	default:
+		dt = h.delta
+		w.writeDataHeader(id, dt)
+	}
+	if delta == 0 {
+		delta = dt
+	}
+	h.time, h.delta, h.len, h.typ, h.stream = ts, delta, n, ct, str
 	for n > 0 {
 		if n > w.size {
 			n -= copy(w.next(w.size), data)
 			data = data[w.size:]
-			w.writeHeader(fmtData, id)
+			w.writeDataHeader(id, dt)
 		} else {
 			copy(w.next(n), data)
 			return
@@ -259,6 +446,14 @@ func (w *writer) WriteFull(id uint32, ts int64, ct uint8, str uint32, data []byt
 	}
 }
 
+// writeDataHeader writes type 3 chunk header repeating the extended timestamp.
+func (w *writer) writeDataHeader(id uint32, dt int64) {
+	w.writeHeader(fmtData, id)
+	if dt >= timeOverflow {
+		be.PutUint32(w.next(4), uint32(dt))
+	}
+}
+
 func (w *writer) writeHeader(fmt uint8, id uint32) {
 	if fmt <<= 6; id < 64 {
 		b := w.next(1)
@@ -310,7 +505,7 @@ func (w *writer) writeDeltaHeader(dt int64) {
 	} else {
 		b := w.next(7)
 		putUint24(b, uint32(timeOverflow))
-		be.PutUint32(b[4:], uint32(dt))
+		be.PutUint32(b[3:], uint32(dt))
 	}
 }
 
@@ -319,7 +514,9 @@ func (w *writer) Flush() (err error) {
 	defer w.mu.Unlock()
 
 	if w.pos > 0 {
-		_, err = w.w.Write(w.buf[:w.pos])
+		var n int
+		n, err = w.w.Write(w.buf[:w.pos])
+		w.sent += uint32(n)
 		w.pos = 0
 	}
 	return
@@ -0,0 +1,291 @@
+package rtmp
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+)
+
+type testMessage struct {
+	id   uint32
+	time int64
+	typ  uint8
+	str  uint32
+	size int
+}
+
+func writeTestMessages(t *testing.T, w *writer, list []testMessage) *bytes.Buffer {
+	for i, it := range list {
+		data := make([]byte, it.size)
+		for j := range data {
+			data[j] = byte(i + j)
+		}
+		w.WriteMessage(it.id, it.time, it.typ, it.str, data)
+	}
+	b := &bytes.Buffer{}
+	w.w = b
+	if err := w.Flush(); err != nil {
+		t.Fatal("flush:", err)
+	}
+	return b
+}
+
+func readTestMessages(t *testing.T, b []byte, size int, list []testMessage) {
+	r := newReader(bytes.NewReader(b))
+	r.size = size
+	for i, it := range list {
+		ch, err := r.ReadChunk()
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if ch.Id != it.id || ch.Time != it.time || ch.Type != it.typ || ch.Stream != it.str || len(ch.Data) != it.size {
+			t.Fatalf("message %d: %+v != %+v", i, ch, it)
+		}
+		for j, v := range ch.Data {
+			if v != byte(i+j) {
+				t.Fatalf("message %d: incorrect data at %d", i, j)
+			}
+		}
+	}
+	if _, err := r.ReadChunk(); err == nil {
+		t.Fatal("unexpected chunk")
+	}
+}
+
+func TestChunkCompression(t *testing.T) {
+	list := []testMessage{
+		{chunkControl, 0, msgSetChunkSize, 0, 4},
+		{chunkVideo, 0, msgVideo, 1, 40},
+		{chunkAudio, 0, msgAudio, 1, 10},
+		{chunkVideo, 40, msgVideo, 1, 300},
+		{chunkAudio, 23, msgAudio, 1, 10},
+		{chunkAudio, 46, msgAudio, 1, 10},
+		{chunkAudio, 69, msgAudio, 1, 10},
+		{chunkVideo, 80, msgVideo, 1, 300},
+		{chunkVideo, 120, msgVideo, 1, 300},
+		{chunkVideo, 160, msgVideo, 1, 128},
+		{chunkVideo, 160, msgAmf0Meta, 1, 128},
+		{chunkVideo, 100, msgVideo, 1, 20},
+		{chunkVideo, 100, msgVideo, 2, 20},
+		{chunkAudio, 92, msgAudio, 1, 10},
+	}
+	w := newWriter(nil)
+	b := writeTestMessages(t, w, list)
+	readTestMessages(t, b.Bytes(), w.size, list)
+
+	full := newWriter(nil)
+	for i, it := range list {
+		full.hdr = make(map[uint32]*chunkHeader)
+		data := make([]byte, it.size)
+		for j := range data {
+			data[j] = byte(i + j)
+		}
+		full.WriteMessage(it.id, it.time, it.typ, it.str, data)
+	}
+	if b.Len() >= full.pos {
+		t.Fatalf("compressed size %d, uncompressed %d", b.Len(), full.pos)
+	}
+}
+
+func TestChunkBufferBoundary(t *testing.T) {
+	// Chunks of messages cross the boundary of the read buffer
+	var list []testMessage
+	for i := 0; i < 50; i++ {
+		list = append(list, testMessage{chunkVideo, int64(i * 40), msgVideo, 1, 1000})
+	}
+	w := newWriter(nil)
+	b := writeTestMessages(t, w, list)
+	readTestMessages(t, b.Bytes(), w.size, list)
+}
+
+func TestChunkHeaderTypes(t *testing.T) {
+	list := []testMessage{
+		{chunkAudio, 0, msgAudio, 1, 10},
+		{chunkAudio, 23, msgAudio, 1, 10},
+		{chunkAudio, 46, msgAudio, 1, 10},
+		{chunkAudio, 69, msgAudio, 1, 12},
+	}
+	w := newWriter(nil)
+	b := writeTestMessages(t, w, list).Bytes()
+	// type 0: 1+11+10, type 2: 1+3+10, type 3: 1+10, type 1: 1+7+12
+	if len(b) != 22+14+11+20 || b[0] != 0x04 || b[22] != 0x84 || b[36] != 0xc4 || b[47] != 0x44 {
+		t.Fatalf("headers: %x", b)
+	}
+	readTestMessages(t, b, w.size, list)
+}
+
+func TestChunkExtendedTime(t *testing.T) {
+	list := []testMessage{
+		{chunkVideo, 0xfffff0, msgVideo, 1, 300},
+		{chunkVideo, 0x1000000, msgVideo, 1, 300},
+		{chunkVideo, 0x1000028, msgVideo, 1, 300},
+		{chunkVideo, 0x1000050, msgVideo, 1, 300},
+		{chunkAudio, 0x2000000, msgAudio, 1, 10},
+		{chunkAudio, 0x3000000, msgAudio, 1, 200},
+		{chunkAudio, 0x4000000, msgAudio, 1, 200},
+		{chunkAudio, 0x5000000, msgAudio, 1, 200},
+	}
+	w := newWriter(nil)
+	b := writeTestMessages(t, w, list)
+	readTestMessages(t, b.Bytes(), w.size, list)
+}
+
+func TestChunkTimeWraparound(t *testing.T) {
+	var list []testMessage
+	// More than 49 days of 25 fps video crossing the 32-bit boundary
+	for ts := int64(0xffff0000); ts < 0x100010000; ts += 40 * 1000 {
+		list = append(list, testMessage{chunkVideo, ts, msgVideo, 1, 200})
+	}
+	list = append(list,
+		testMessage{chunkVideo, 0x100020000, msgVideo, 2, 20},
+		testMessage{chunkVideo, 0x100030000, msgVideo, 1, 20},
+	)
+	w := newWriter(nil)
+	b := writeTestMessages(t, w, list)
+	readTestMessages(t, b.Bytes(), w.size, list)
+}
+
+func TestChunkExtendedTimeNotRepeated(t *testing.T) {
+	// Type 0 header with extended timestamp followed by type 3 chunk without it
+	b := []byte{0x06, 0xff, 0xff, 0xff, 0x00, 0x00, 0x82, msgVideo, 1, 0, 0, 0, 0x01, 0x00, 0x00, 0x00}
+	for i := 0; i < 128; i++ {
+		b = append(b, byte(i))
+	}
+	b = append(b, 0xc6, 0xaa, 0xbb)
+	r := newReader(bytes.NewReader(b))
+	ch, err := r.ReadChunk()
+	if err != nil {
+		t.Fatal("read chunk:", err)
+	}
+	if ch.Time != 0x1000000 || len(ch.Data) != 130 || ch.Data[128] != 0xaa || ch.Data[129] != 0xbb {
+		t.Fatalf("chunk: %+v", ch)
+	}
+}
+
+func TestChunkExtendedTimeShortChunk(t *testing.T) {
+	// The last type 3 chunk is shorter than the extended timestamp and nothing follows it
+	b := []byte{0x06, 0xff, 0xff, 0xff, 0x00, 0x00, 0x82, msgVideo, 1, 0, 0, 0, 0x01, 0x00, 0x00, 0x00}
+	b = append(b, make([]byte, 128)...)
+	b = append(b, 0xc6, 0xaa, 0xbb)
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go pw.Write(b)
+	r := newReader(pr)
+	done := make(chan *chunk, 1)
+	go func() {
+		ch, _ := r.ReadChunk()
+		done <- ch
+	}()
+	select {
+	case ch := <-done:
+		if ch == nil || len(ch.Data) != 130 || ch.Data[128] != 0xaa {
+			t.Fatalf("chunk: %+v", ch)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read chunk: timeout")
+	}
+}
+
+func TestWriterBandwidth(t *testing.T) {
+	w := newWriter(nil)
+	steps := []struct {
+		v      uint32
+		limit  uint8
+		window uint32
+		ok     bool
+	}{
+		{1000, limitDynamic, 0, false},
+		{2000, limitSoft, 2000, true},
+		{3000, limitSoft, 2000, true},
+		{1500, limitSoft, 1500, true},
+		{4000, limitDynamic, 1500, false},
+		{5000, limitHard, 5000, true},
+		{6000, limitDynamic, 6000, true},
+		{7000, 3, 6000, false},
+	}
+	for i, it := range steps {
+		if window, ok := w.setBandwidth(it.v, it.limit); window != it.window || ok != it.ok {
+			t.Fatalf("step %d: window %d %v, expected %d %v", i, window, ok, it.window, it.ok)
+		}
+	}
+}
+
+func TestWriterWait(t *testing.T) {
+	w := newWriter(&bytes.Buffer{})
+	w.setBandwidth(1000, limitHard)
+	w.WriteMessage(chunkVideo, 0, msgVideo, 1, make([]byte, 1200))
+	w.Flush()
+
+	done := make(chan bool)
+	go func() {
+		done <- w.wait()
+	}()
+	select {
+	case <-done:
+		t.Fatal("wait returned before acknowledgement")
+	case <-time.After(50 * time.Millisecond):
+	}
+	w.acknowledge(w.sent - 100)
+	if !<-done {
+		t.Fatal("wait failed")
+	}
+	w.acknowledge(0)
+	go func() {
+		done <- w.wait()
+	}()
+	w.close()
+	if <-done {
+		t.Fatal("wait succeeded after close")
+	}
+}
+
+func TestChunkSizeChange(t *testing.T) {
+	w := newWriter(nil)
+	w.WriteMessage(chunkVideo, 0, msgVideo, 1, make([]byte, 300))
+	w.SetChunkSize(1000)
+	w.WriteMessage(chunkVideo, 40, msgVideo, 1, make([]byte, 300))
+	b := &bytes.Buffer{}
+	w.w = b
+	w.Flush()
+	// 300 bytes are written in 3 chunks of 128 bytes, then in a single chunk of 1000 bytes
+	if n := 12 + 300 + 2 + 16 + 4 + 300; b.Len() != n {
+		t.Fatalf("length %d != %d", b.Len(), n)
+	}
+	r := newReader(b)
+	for _, size := range []int{300, 4, 300} {
+		ch, err := r.ReadChunk()
+		if err != nil {
+			t.Fatal("read chunk:", err)
+		}
+		if len(ch.Data) != size {
+			t.Fatalf("chunk %+v", ch)
+		}
+		if ch.Type == msgSetChunkSize {
+			r.size = int(be.Uint32(ch.Data))
+		}
+	}
+}
+
+func TestChunkAbort(t *testing.T) {
+	w := newWriter(nil)
+	w.WriteMessage(chunkVideo, 0, msgVideo, 1, make([]byte, 200))
+	// Cut the message after the first chunk
+	w.pos = 12 + 128
+	w.WriteMessage(chunkControl, 0, msgAbort, 0, []byte{0, 0, 0, byte(chunkVideo)})
+	w.hdr = make(map[uint32]*chunkHeader)
+	w.WriteMessage(chunkVideo, 40, msgVideo, 1, make([]byte, 20))
+	b := &bytes.Buffer{}
+	w.w = b
+	w.Flush()
+
+	r := newReader(b)
+	ch, err := r.ReadChunk()
+	if err != nil || ch.Type != msgAbort {
+		t.Fatalf("read chunk: %+v %v", ch, err)
+	}
+	r.abort(be.Uint32(ch.Data))
+	if ch, err = r.ReadChunk(); err != nil || ch.Time != 40 || len(ch.Data) != 20 {
+		t.Fatalf("read chunk: %+v %v", ch, err)
+	}
+}
@@ -1 +1,96 @@
 package rtmp
+
+import (
+	"context"
+	"net/url"
+	"strings"
+)
+
+// DefaultClientInfo is used by Connect if the client info is not specified.
+var DefaultClientInfo = ClientInfo{
+	FlashVer:     "FMLE/3.0 (compatible; FMSc/1.0)",
+	Capabilities: 239,
+	AudioCodecs:  3575,
+	VideoCodecs:  252,
+}
+
+// ConnectResult represents the result of connect command.
+type ConnectResult struct {
+	FMSVer         string
+	Capabilities   int
+	Code           string
+	Description    string
+	ObjectEncoding uint8
+}
+
+type connectProperties struct {
+	FMSVer       string `amf:"fmsVer"`
+	Capabilities int    `amf:"capabilities"`
+}
+
+type connectInfo struct {
+	Level          string `amf:"level"`
+	Code           string `amf:"code"`
+	Description    string `amf:"description"`
+	ObjectEncoding uint8  `amf:"objectEncoding"`
+}
+
+// Connect connects to the application and returns the result.
+// App and TcURL of the client info are filled from the dial URL if empty.
+// The connection switches to AMF3 commands when the server agrees on object encoding 3.
+func (c *Conn) Connect(ctx context.Context, info *ClientInfo, args ...interface{}) (*ConnectResult, error) {
+	ci := DefaultClientInfo
+	if info != nil {
+		ci = *info
+	}
+	if u := c.url; u != nil {
+		app := urlApp(u)
+		if ci.App == "" {
+			ci.App = app
+		}
+		if ci.TcURL == "" {
+			ci.TcURL = u.Scheme + "://" + u.Host + "/" + app
+		}
+	}
+	res, err := c.req.request(ctx, c, 0, "connect", append([]interface{}{&ci}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	props, st := &connectProperties{}, &connectInfo{}
+	if err = res.Decode(props); err != nil {
+		return nil, err
+	}
+	if err = res.Decode(st); err != nil {
+		return nil, err
+	}
+	c.app = ci.App
+	c.amf3 = st.ObjectEncoding == 3 && ci.ObjectEncoding == 3
+	return &ConnectResult{
+		FMSVer:         props.FMSVer,
+		Capabilities:   props.Capabilities,
+		Code:           st.Code,
+		Description:    st.Description,
+		ObjectEncoding: st.ObjectEncoding,
+	}, nil
+}
+
+// urlApp returns the application name of the URL in the same way as librtmp and ffmpeg do:
+// rtmp://host/app[/instance]/stream. A path of the single segment is the application
+// followed by the query, if any.
+func urlApp(u *url.URL) string {
+	path := strings.TrimPrefix(u.Path, "/")
+	i := strings.IndexByte(path, '/')
+	if i < 0 {
+		if u.RawQuery != "" {
+			path += "?" + u.RawQuery
+		}
+		return path
+	}
+	// The instance is a part of the application unless the rest is a single stream name
+	// or it is prefixed with the stream type, such as mp4:
+	rest := path[i+1:]
+	if j := strings.IndexByte(rest, '/'); j >= 0 && !strings.Contains(rest[:j], ":") {
+		return path[:i+1+j]
+	}
+	return path[:i]
+}
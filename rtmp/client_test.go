@@ -0,0 +1,332 @@
+package rtmp
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pixelbender/go-rtmp/amf"
+	"github.com/pixelbender/go-rtmp/flv"
+)
+
+type rejectHandler struct {
+	NopHandler
+}
+
+func (rejectHandler) OnConnect(c *Conn, info *ClientInfo) error {
+	if info.App != "live" {
+		return &StatusError{Code: "NetConnection.Connect.InvalidApp", Description: info.App}
+	}
+	return nil
+}
+
+func TestConnect(t *testing.T) {
+	uri := listenTestServer(t, &Server{Handler: rejectHandler{}})
+	c, err := Dial(uri)
+	if err != nil {
+		t.Fatal("dial:", err)
+	}
+	defer c.Close()
+	res, err := c.Connect(context.Background(), nil)
+	if err != nil {
+		t.Fatal("connect:", err)
+	}
+	if res.Code != "NetConnection.Connect.Success" || res.FMSVer == "" || res.ObjectEncoding != 0 {
+		t.Fatalf("connect: %+v", res)
+	}
+
+	// The stream name is not a part of the application
+	c, err = Dial(uri + "/stream")
+	if err != nil {
+		t.Fatal("dial:", err)
+	}
+	defer c.Close()
+	if _, err = c.Connect(context.Background(), nil); err != nil {
+		t.Fatal("connect:", err)
+	}
+
+	c, err = Dial(uri)
+	if err != nil {
+		t.Fatal("dial:", err)
+	}
+	defer c.Close()
+	_, err = c.Connect(context.Background(), &ClientInfo{App: "other"})
+	if e, ok := err.(*StatusError); !ok || e.Code != "NetConnection.Connect.InvalidApp" || e.Description != "other" {
+		t.Fatalf("connect: %v", err)
+	}
+}
+
+func TestURLApp(t *testing.T) {
+	for uri, app := range map[string]string{
+		"rtmp://host/live":                 "live",
+		"rtmp://host/live?token=1":         "live?token=1",
+		"rtmp://host/live/stream":          "live",
+		"rtmp://host/live/stream?key=1":    "live",
+		"rtmp://host/live/inst/stream":     "live/inst",
+		"rtmp://host/vod/mp4:dir/file.mp4": "vod",
+		"rtmp://host/":                     "",
+	} {
+		u, _ := url.Parse(uri)
+		if v := urlApp(u); v != app {
+			t.Errorf("%s: app %q != %q", uri, v, app)
+		}
+	}
+}
+
+func TestPublish(t *testing.T) {
+	uri := listenTestServer(t, &Server{})
+	c, err := Dial(uri)
+	if err != nil {
+		t.Fatal("dial:", err)
+	}
+	defer c.Close()
+	if _, err = c.Connect(context.Background(), nil); err != nil {
+		t.Fatal("connect:", err)
+	}
+	s, err := c.Publish(context.Background(), "test", "live")
+	if err != nil {
+		t.Fatal("publish:", err)
+	}
+	enc := amf.NewEncoder(0)
+	enc.WriteString("onMetaData")
+	enc.Encode(map[string]interface{}{"width": 1280})
+	meta := enc.Bytes()
+	if err = s.WriteMetadata(0, meta); err != nil {
+		t.Fatal("write metadata:", err)
+	}
+	header := []byte{0x17, 0, 0, 0, 0, 1, 0x64, 0, 0x1f, 0xff}
+	if err = s.WriteVideo(0, header); err != nil {
+		t.Fatal("write video:", err)
+	}
+
+	pc, ps := dialTestStream(t, uri)
+	defer pc.Close()
+	ps.Play("test")
+	readStatus(t, pc)
+	readStatus(t, pc)
+	readMedia(t, pc, msgAmf0Meta) // |RtmpSampleAccess
+	if ch := readMedia(t, pc, msgAmf0Meta); !bytes.Equal(ch.Data, meta) {
+		t.Fatalf("metadata: %x", ch.Data)
+	}
+	if ch := readMedia(t, pc, msgVideo); !bytes.Equal(ch.Data, header) {
+		t.Fatalf("video: %x", ch.Data)
+	}
+	audio := []byte{0xaf, 1, 0x21, 0x10}
+	if err = s.WriteAudio(23, audio); err != nil {
+		t.Fatal("write audio:", err)
+	}
+	if ch := readMedia(t, pc, msgAudio); !bytes.Equal(ch.Data, audio) || ch.Time != 23 {
+		t.Fatalf("audio: %+v", ch)
+	}
+
+	c2, err := Dial(uri)
+	if err != nil {
+		t.Fatal("dial:", err)
+	}
+	defer c2.Close()
+	c2.Connect(context.Background(), nil)
+	if _, err = c2.Publish(context.Background(), "test", "live"); err == nil || err.(*StatusError).Code != "NetStream.Publish.BadName" {
+		t.Fatal("publish:", err)
+	}
+}
+
+func TestPlay(t *testing.T) {
+	uri := listenTestServer(t, &Server{})
+	dial := func() *Conn {
+		c, err := Dial(uri)
+		if err != nil {
+			t.Fatal("dial:", err)
+		}
+		if _, err = c.Connect(context.Background(), nil); err != nil {
+			t.Fatal("connect:", err)
+		}
+		return c
+	}
+	pc := dial()
+	defer pc.Close()
+	pub, err := pc.Publish(context.Background(), "test", "live")
+	if err != nil {
+		t.Fatal("publish:", err)
+	}
+	header := []byte{0x17, 0, 0, 0, 0, 1, 0x64, 0, 0x1f, 0xff}
+	pub.WriteVideo(0, header)
+
+	c := dial()
+	defer c.Close()
+	s, err := c.Play(context.Background(), "test")
+	if err != nil {
+		t.Fatal("play:", err)
+	}
+	expectEvent := func(code string) {
+		select {
+		case st := <-s.Events():
+			if st.Code != code {
+				t.Fatalf("event: %+v", st)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("event: timeout")
+		}
+	}
+	expectEvent("NetStream.Play.Reset")
+	expectEvent("NetStream.Play.Start")
+	select {
+	case ev := <-s.Controls():
+		if ev.Type != ControlStreamBegin || ev.Stream != s.id {
+			t.Fatalf("control: %+v", ev)
+		}
+	default:
+		t.Fatal("control: no stream begin")
+	}
+	p, err := s.ReadPacket()
+	if err != nil || p.Type != flv.TypeData {
+		t.Fatalf("read packet: %+v %v", p, err)
+	}
+	if p, err = s.ReadPacket(); err != nil || p.Type != flv.TypeVideo || !bytes.Equal(p.Data, header) {
+		t.Fatalf("read packet: %+v %v", p, err)
+	}
+	frame := []byte{0x27, 1, 0, 0, 0, 0, 0, 0, 2, 0x41, 0x9a}
+	pub.WriteVideo(40, frame)
+	if p, err = s.ReadPacket(); err != nil || p.Time != 40 || !bytes.Equal(p.Data, frame) {
+		t.Fatalf("read packet: %+v %v", p, err)
+	}
+	pub.Close()
+	expectEvent("NetStream.Play.UnpublishNotify")
+
+	c.Close()
+	if _, err = s.ReadPacket(); err == nil {
+		t.Fatal("read packet: no error after close")
+	}
+}
+
+type tlsHandler struct {
+	NopHandler
+	secure chan bool
+}
+
+func (h *tlsHandler) OnConnect(c *Conn, info *ClientInfo) error {
+	h.secure <- c.TLS() != nil
+	return nil
+}
+
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestDialTLS(t *testing.T) {
+	cert, pool := testCertificate(t)
+	h := &tlsHandler{secure: make(chan bool, 1)}
+	srv := &Server{Handler: h, TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}}}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen:", err)
+	}
+	defer l.Close()
+	go srv.ServeTLS(l, "", "")
+
+	uri := "rtmps://" + l.Addr().String() + "/live"
+	if _, err = Dial(uri); err == nil {
+		t.Fatal("dial: untrusted certificate accepted")
+	}
+	c, err := DialTLS(uri, &tls.Config{RootCAs: pool})
+	if err != nil {
+		t.Fatal("dial:", err)
+	}
+	defer c.Close()
+	if st := c.TLS(); st == nil || !st.HandshakeComplete || len(st.VerifiedChains) == 0 {
+		t.Fatalf("tls: %+v", st)
+	}
+	if _, err = c.Connect(context.Background(), nil); err != nil {
+		t.Fatal("connect:", err)
+	}
+	if !<-h.secure {
+		t.Fatal("server: connection is not secured")
+	}
+}
+
+func TestDialUnsupportedScheme(t *testing.T) {
+	if _, err := Dial("http://127.0.0.1/live"); err == nil {
+		t.Fatal("dial: no error")
+	}
+}
+
+type callHandler struct {
+	NopHandler
+	hang chan struct{}
+}
+
+func (h *callHandler) OnCall(c *Conn, name string, args []interface{}) (interface{}, error) {
+	switch name {
+	case "add":
+		return args[1].(float64) + args[2].(float64), nil
+	case "fail":
+		return nil, &StatusError{Level: "warning", Code: "App.Failed", Description: "failed"}
+	case "hang":
+		<-h.hang
+	}
+	return nil, nil
+}
+
+func TestCall(t *testing.T) {
+	h := &callHandler{hang: make(chan struct{})}
+	defer close(h.hang)
+	uri := listenTestServer(t, &Server{Handler: h})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := (&Dialer{}).DialContext(ctx, uri)
+	if err != nil {
+		t.Fatal("dial:", err)
+	}
+	defer c.Close()
+
+	var sum int
+	if err = c.Call(ctx, "add", &sum, 2, 3); err != nil || sum != 5 {
+		t.Fatalf("call: %v %v", sum, err)
+	}
+	err = c.Call(ctx, "fail", nil)
+	if e, ok := err.(*StatusError); !ok || e.Level != "warning" || e.Code != "App.Failed" || e.Description != "failed" {
+		t.Fatalf("call: %#v", err)
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- c.Call(ctx, "hang", nil)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	c.Close()
+	if err = <-errc; err == nil || err == ErrTimeout {
+		t.Fatal("call after close:", err)
+	}
+	if err = c.Call(ctx, "add", &sum, 1, 1); err == nil || err == ErrTimeout {
+		t.Fatal("call after close:", err)
+	}
+}
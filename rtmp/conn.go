@@ -1,15 +1,32 @@
 package rtmp
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
-	"log"
+	"fmt"
 	"net"
+	"net/url"
 	"sync"
 	"time"
+
+	"github.com/pixelbender/go-rtmp/amf"
 )
 
 var ErrTimeout = errors.New("rtmp: i/o timeout")
 
+// MaxChunkSize is the maximum chunk size allowed by the protocol.
+const MaxChunkSize = 0x7fffffff
+
+// ProtocolError represents the protocol violation by the peer.
+type ProtocolError struct {
+	Message string
+}
+
+func (e *ProtocolError) Error() string {
+	return "rtmp: protocol error: " + e.Message
+}
+
 var bufferSize = 4096
 
 // A Conn represents the RTMP connection and implements the RTMP protocol over net.Conn interface.
@@ -21,8 +38,34 @@ type Conn struct {
 	RequestTimeout time.Duration
 	req            requestMux
 
-	str   map[int64]*Stream
-	strmu sync.RWMutex
+	// PacketBuffer is the number of media packets buffered for ReadPacket of each stream created after it is set.
+	// If the buffer is full, packets are dropped up to the next video key frame.
+	PacketBuffer int
+	// ReportDrops makes ReadPacket return ErrSlowReader in place of dropped packets.
+	ReportDrops bool
+
+	str    map[int64]*Stream
+	strmu  sync.RWMutex
+	strseq int64
+
+	server *Server
+	app    string
+	url    *url.URL
+	amf3   bool
+
+	ackSize uint32
+	rtmpe   bool
+
+	events    chan *Status
+	handlers  map[string]HandlerFunc
+	handlermu sync.RWMutex
+
+	epoch  time.Time
+	pings  map[uint32]chan struct{}
+	pingmu sync.Mutex
+
+	done chan struct{}
+	err  error
 }
 
 // NewConn creates a Conn connection on the given net.Conn
@@ -32,99 +75,445 @@ func NewConn(inner net.Conn) *Conn {
 		r:              newReader(inner),
 		w:              newWriter(inner),
 		RequestTimeout: 5 * time.Second,
+		PacketBuffer:   256,
 		str:            make(map[int64]*Stream),
+		epoch:          time.Now(),
+		pings:          make(map[uint32]chan struct{}),
+		events:         make(chan *Status, 16),
+		done:           make(chan struct{}),
 	}
 	return c
 }
 
+// Serve reads and handles messages until the connection is closed.
 func (c *Conn) Serve() error {
-	//	var str *Stream
+	err := c.serve()
+	c.err = err
+	c.w.close()
+	c.req.close(err)
+	close(c.done)
+	return err
+}
+
+func (c *Conn) serve() error {
 	for {
 		ch, err := c.r.ReadChunk()
 		if err != nil {
 			return err
 		}
+		if seq, ok := c.r.acknowledge(); ok {
+			b := make([]byte, 4)
+			be.PutUint32(b, seq)
+			c.w.WriteMessage(chunkControl, 0, msgAck, 0, b)
+			if err = c.w.Flush(); err != nil {
+				return err
+			}
+		}
 		switch ch.Type {
 		case msgSetChunkSize:
+			if len(ch.Data) != 4 {
+				return &ProtocolError{"malformed set chunk size message"}
+			}
+			n := be.Uint32(ch.Data)
+			if n < 1 || n > MaxChunkSize {
+				return &ProtocolError{fmt.Sprintf("invalid chunk size %d", n)}
+			}
+			c.r.size = int(n)
+		case msgAbort:
+			if len(ch.Data) == 4 {
+				c.r.abort(be.Uint32(ch.Data))
+			}
+		case msgAck:
 			if len(ch.Data) == 4 {
-				c.r.size = int(be.Uint32(ch.Data))
-				log.Printf("chunk size=%v", c.r.size)
+				c.w.acknowledge(be.Uint32(ch.Data))
 			}
 		case msgAckSize:
 			if len(ch.Data) == 4 {
-				//r.ack = int(getUint32(result.Data))
+				c.r.window = be.Uint32(ch.Data)
 			}
 		case msgSetBandwidth:
 			if len(ch.Data) == 5 {
-				//r.bw = int(getUint32(result.Data))
+				if v, ok := c.w.setBandwidth(be.Uint32(ch.Data), ch.Data[4]); ok && v != c.ackSize {
+					c.setAckSize(v)
+					if err = c.w.Flush(); err != nil {
+						return err
+					}
+				}
+			}
+		case msgUserControl:
+			if err = c.handleControl(ch.Data); err != nil {
+				return err
 			}
 		case msgAmf0Command, msgAmf3Command:
-			c.req.handleChunk(ch)
-		default:
-			log.Printf("chunk %+v", ch)
+			if err = c.handleCommand(ch); err != nil {
+				return err
+			}
+		case msgAudio, msgVideo, msgAmf0Meta, msgAmf3Meta:
+			if s := c.getStream(int64(ch.Stream)); s != nil {
+				s.handleMedia(ch)
+			}
+		}
+	}
+}
+
+func (c *Conn) handleCommand(ch *chunk) error {
+	d := make([]byte, len(ch.Data))
+	copy(d, ch.Data)
+	if ch.Type == msgAmf3Command && len(d) > 0 {
+		d = d[1:]
+	}
+	dec := amf.NewDecoder(0, d)
+	name, err := dec.ReadString()
+	if err != nil {
+		return err
+	}
+	id, err := dec.ReadInt()
+	if err != nil {
+		return err
+	}
+	if c.req.handleResponse(id, name, dec) {
+		return nil
+	}
+	if name == "onStatus" && c.server == nil {
+		if s := c.getStream(int64(ch.Stream)); s != nil {
+			s.handleStatus(dec)
+			return nil
+		}
+		if c.handler(name) == nil {
+			c.handleStatus(dec)
+			return nil
+		}
+	}
+	if h := c.handler(name); h != nil {
+		return c.handleCall(h, ch.Stream, name, id, dec)
+	}
+	if c.server != nil {
+		return c.server.serveCommand(c, ch.Stream, name, id, dec)
+	}
+	if id != 0 {
+		c.writeCommand(0, "_error", id, nil, newError(NetConnectionCallFailed, "Method not found ("+name+")."))
+		return c.w.Flush()
+	}
+	return nil
+}
+
+// Events returns the channel of onStatus events received for the connection,
+// such as NetConnection.Connect.Closed and statuses of streams not created by the connection.
+// Events are dropped if the channel is not read or the onStatus handler is registered by HandleFunc.
+func (c *Conn) Events() <-chan *Status {
+	return c.events
+}
+
+// SendStatus sends onStatus event to the connection.
+func (c *Conn) SendStatus(st *Status) error {
+	c.writeCommand(0, "onStatus", 0, nil, st)
+	return c.w.Flush()
+}
+
+func (c *Conn) handleStatus(dec amf.Decoder) {
+	dec.Skip()
+	st, err := decodeStatus(dec)
+	if err != nil {
+		return
+	}
+	select {
+	case c.events <- st:
+	default:
+	}
+}
+
+// HandleFunc registers the handler for commands with the name invoked by the peer, such as onBWDone or onStatus.
+// The result or error is sent to the peer if it expects the result.
+// Handlers are called by the goroutine reading the connection, so they must not wait for responses of the peer.
+// Handlers are called for commands of the server connection before the server handles them.
+func (c *Conn) HandleFunc(name string, h HandlerFunc) {
+	c.handlermu.Lock()
+	defer c.handlermu.Unlock()
+	if c.handlers == nil {
+		c.handlers = make(map[string]HandlerFunc)
+	}
+	c.handlers[name] = h
+}
+
+func (c *Conn) handler(name string) HandlerFunc {
+	c.handlermu.RLock()
+	defer c.handlermu.RUnlock()
+	return c.handlers[name]
+}
+
+func (c *Conn) handleCall(h HandlerFunc, str uint32, name string, id int64, dec amf.Decoder) error {
+	call := &Call{Conn: c, Stream: str, Name: name, ID: id}
+	dec.Decode(&call.Object)
+	for {
+		var v interface{}
+		if dec.Decode(&v) != nil {
+			break
+		}
+		call.Args = append(call.Args, v)
+	}
+	res, err := h(call)
+	if id == 0 {
+		return nil
+	}
+	if err != nil {
+		c.writeCommand(str, "_error", id, nil, errorStatus(err, NetConnectionCallFailed))
+	} else {
+		c.writeCommand(str, "_result", id, nil, res)
+	}
+	return c.w.Flush()
+}
+
+func (c *Conn) writeCommand(str uint32, name string, id int64, args ...interface{}) error {
+	enc := amf.NewEncoder(0)
+	enc.WriteString(name)
+	enc.WriteInt(id)
+	for _, it := range args {
+		if st, ok := it.(*Status); ok {
+			it = st.object()
+		}
+		if err := enc.Encode(it); err != nil {
+			return err
+		}
+	}
+	if c.amf3 {
+		c.w.WriteMessage(chunkCommand, 0, msgAmf3Command, str, append([]byte{0}, enc.Bytes()...))
+	} else {
+		c.w.WriteMessage(chunkCommand, 0, msgAmf0Command, str, enc.Bytes())
+	}
+	return nil
+}
+
+func (c *Conn) handleControl(b []byte) error {
+	if len(b) < 6 {
+		return nil
+	}
+	typ, v := uint8(be.Uint16(b)), be.Uint32(b[2:])
+	switch typ {
+	case ControlPingRequest:
+		c.writeControl(ControlPingResponse, v)
+		return c.w.Flush()
+	case ControlPingResponse:
+		c.pingmu.Lock()
+		if ch, ok := c.pings[v]; ok {
+			delete(c.pings, v)
+			close(ch)
 		}
+		c.pingmu.Unlock()
+		return nil
+	}
+	ev := &UserControl{Type: typ, Stream: v}
+	if typ == ControlSetBufferLength {
+		if len(b) < 10 {
+			return nil
+		}
+		ev.BufferLength = time.Duration(be.Uint32(b[6:])) * time.Millisecond
+	}
+	if s := c.getStream(int64(v)); s != nil {
+		s.handleControl(ev)
+	}
+	return nil
+}
+
+// writeControl writes the user control event with the event data.
+func (c *Conn) writeControl(typ uint8, args ...uint32) {
+	b := make([]byte, 2+4*len(args))
+	be.PutUint16(b, uint16(typ))
+	for i, v := range args {
+		be.PutUint32(b[2+4*i:], v)
+	}
+	c.w.WriteMessage(chunkControl, 0, msgUserControl, 0, b)
+}
+
+// Ping sends the ping request and returns the round-trip time when the peer responds.
+func (c *Conn) Ping(ctx context.Context) (time.Duration, error) {
+	ts := time.Now()
+	ch := make(chan struct{})
+	c.pingmu.Lock()
+	v := uint32(ts.Sub(c.epoch) / time.Millisecond)
+	for c.pings[v] != nil {
+		v++
+	}
+	c.pings[v] = ch
+	c.pingmu.Unlock()
+	defer func() {
+		c.pingmu.Lock()
+		delete(c.pings, v)
+		c.pingmu.Unlock()
+	}()
+
+	c.writeControl(ControlPingRequest, v)
+	if err := c.w.Flush(); err != nil {
+		return 0, err
+	}
+	select {
+	case <-ch:
+		return time.Since(ts), nil
+	case <-c.done:
+		return 0, c.err
+	case <-ctx.Done():
+		if err := ctx.Err(); err != context.DeadlineExceeded {
+			return 0, err
+		}
+		return 0, ErrTimeout
+	}
+}
+
+// TLS returns the state of the TLS connection or nil if the connection is not secured by TLS.
+func (c *Conn) TLS() *tls.ConnectionState {
+	if tc, ok := c.Conn.(*tls.Conn); ok {
+		st := tc.ConnectionState()
+		return &st
+	}
+	return nil
+}
+
+// encrypt switches the connection to RTMPE with the shared secret of the key exchange.
+func (c *Conn) encrypt(dh *dhKey, peer []byte) error {
+	secret, err := dh.secret(peer)
+	if err != nil {
+		return err
+	}
+	c.Conn = newCryptConn(c.Conn, secret, dh.pub, peer)
+	c.r = newReader(c.Conn)
+	c.w.w = c.Conn
+	return nil
+}
+
+// SetChunkSize sends Set Chunk Size message to the peer and writes subsequent messages with chunks of n bytes.
+func (c *Conn) SetChunkSize(n int) error {
+	if n < 1 || n > MaxChunkSize {
+		return fmt.Errorf("rtmp: invalid chunk size %d", n)
 	}
+	c.w.SetChunkSize(n)
+	return c.w.Flush()
+}
+
+// setAckSize sends Window Acknowledgement Size message, the peer acknowledges every n bytes received.
+func (c *Conn) setAckSize(n uint32) {
+	c.ackSize = n
+	b := make([]byte, 4)
+	be.PutUint32(b, n)
+	c.w.WriteMessage(chunkControl, 0, msgAckSize, 0, b)
+}
+
+func (c *Conn) getStream(id int64) *Stream {
+	c.strmu.RLock()
+	defer c.strmu.RUnlock()
+	return c.str[id]
+}
+
+func (c *Conn) newStream() *Stream {
+	c.strmu.Lock()
+	defer c.strmu.Unlock()
+	c.strseq++
+	s := &Stream{conn: c, id: uint32(c.strseq)}
+	c.str[c.strseq] = s
+	return s
+}
+
+func (c *Conn) deleteStream(id int64) *Stream {
+	c.strmu.Lock()
+	defer c.strmu.Unlock()
+	s := c.str[id]
+	delete(c.str, id)
+	return s
+}
+
+// Call invokes the remote method with the arguments and decodes the returned value into result, if not nil.
+// It returns *StatusError if the peer responds with _error, or the connection error if it is closed.
+func (c *Conn) Call(ctx context.Context, name string, result interface{}, args ...interface{}) error {
+	res, err := c.req.request(ctx, c, 0, name, append([]interface{}{nil}, args...)...)
+	if err != nil || result == nil {
+		return err
+	}
+	if err = res.Skip(); err != nil {
+		return err
+	}
+	return res.Decode(result)
 }
 
 func (c *Conn) Request(name string, args ...interface{}) (*Response, error) {
-	return c.req.request(c, 0, name, args...)
+	ctx, cancel := context.WithTimeout(context.Background(), c.RequestTimeout)
+	defer cancel()
+	return c.req.request(ctx, c, 0, name, args...)
+}
+
+func (c *Conn) CreateStream() (*Stream, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.RequestTimeout)
+	defer cancel()
+	return c.createStream(ctx)
 }
 
-func (c *Conn) CreateStream() (str *Stream, err error) {
+func (c *Conn) createStream(ctx context.Context) (str *Stream, err error) {
 	var res *Response
-	if res, err = c.Request("createStream", nil); err != nil {
+	if res, err = c.req.request(ctx, c, 0, "createStream", nil); err != nil {
 		return
 	}
 	if err = res.Skip(); err != nil {
 		return
 	}
 	var id int64
-	if id, err = res.DecodeInt(); err != nil {
+	if id, err = res.ReadInt(); err != nil {
 		return
 	}
-	str = &Stream{conn: c, id: uint32(id)}
+	str = &Stream{
+		conn:     c,
+		id:       uint32(id),
+		events:   make(chan *Status, 16),
+		controls: make(chan *UserControl, 16),
+		packets:  make(chan *Packet, c.PacketBuffer),
+	}
 	c.strmu.Lock()
 	c.str[id] = str
 	c.strmu.Unlock()
 	return
 }
 
-func (c *Conn) Handshake() (err error) {
-	b := make([]byte, 3073)
-	ch := &handshakeHello{
-		Proto:   0x03,
-		Time:    uint32(0),
-		Version: uint32(0),
+// Publish creates a stream and publishes it with the name and type: live, record or append.
+func (c *Conn) Publish(ctx context.Context, name, typ string) (*Stream, error) {
+	c.releaseStream(name)
+	s, err := c.createStream(ctx)
+	if err != nil {
+		return nil, err
 	}
-	ts := time.Now()
-	if _, err = c.Write(ch.pack(b, nil)); err != nil {
-		return
+	if err = s.publish(ctx, name, typ); err != nil {
+		return nil, err
 	}
-	if _, err = c.Read(b[:3073]); err != nil {
-		return
-	}
-	rt := time.Now().Sub(ts)
-	sh, sa := &handshakeHello{}, &handshakeAck{}
-	sh.unpack(b[:1537], nil)
+	return s, nil
+}
 
-	b = b[1537:3073]
-	sa.unpack(b)
-	if sh.Proto != ch.Proto {
-		return ErrHandshake
+// Play creates a stream and starts playing the stream name.
+// Media is read by ReadPacket and status of playback is delivered by Events of the stream.
+func (c *Conn) Play(ctx context.Context, name string) (*Stream, error) {
+	s, err := c.createStream(ctx)
+	if err != nil {
+		return nil, err
 	}
-	ca := &handshakeAck{
-		Time:     sh.Time,
-		RecvTime: uint32(rt / time.Millisecond),
+	if err = s.Play(name); err != nil {
+		return nil, err
 	}
-	_, err = c.Write(ca.pack(b))
+	return s, nil
+}
 
-	log.Printf("handshake done")
+// releaseStream sends releaseStream and FCPublish commands expected by most servers before publishing.
+func (c *Conn) releaseStream(name string) {
+	c.writeCommand(0, "releaseStream", c.req.nextID(), nil, name)
+	c.writeCommand(0, "FCPublish", c.req.nextID(), nil, name)
+}
 
-	c.w.size = 10000
-	be.PutUint32(b, uint32(c.w.size))
-	c.w.WriteFull(0x2, 0, msgSetChunkSize, 0, b[:4])
-	be.PutUint32(b, 250000)
-	c.w.WriteFull(0x2, 0, msgAckSize, 0, b[:4])
+// Chunk size and acknowledgement window size set by the client after the handshake.
+const (
+	clientChunkSize     = 10000
+	clientAckWindowSize = 250000
+)
 
-	return nil
+// Handshake performs the client handshake, then sets the chunk size of 10000 bytes
+// and the acknowledgement window of 250000 bytes. Use SetChunkSize to change the chunk size
+// or Dialer to configure both.
+func (c *Conn) Handshake() error {
+	if err := clientHandshake(c); err != nil {
+		return err
+	}
+	c.setAckSize(clientAckWindowSize)
+	return c.SetChunkSize(clientChunkSize)
 }
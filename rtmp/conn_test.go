@@ -0,0 +1,287 @@
+package rtmp
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAcknowledgement(t *testing.T) {
+	p, q := net.Pipe()
+	a, b := NewConn(p), NewConn(q)
+	defer a.Close()
+	defer b.Close()
+	go b.Serve()
+
+	go func() {
+		a.setAckSize(1000)
+		for i := 0; i < 4; i++ {
+			a.w.WriteMessage(chunkVideo, int64(i*40), msgVideo, 1, make([]byte, 400))
+		}
+		a.w.Flush()
+	}()
+	ch := readMedia(t, a, msgAck)
+	if seq := be.Uint32(ch.Data); seq < 1000 {
+		t.Fatalf("acknowledged %d bytes", seq)
+	}
+}
+
+func TestPeerBandwidth(t *testing.T) {
+	p, q := net.Pipe()
+	a, b := NewConn(p), NewConn(q)
+	defer a.Close()
+	defer b.Close()
+	go b.Serve()
+
+	go func() {
+		d := []byte{0, 0, 0x13, 0x88, limitHard}
+		a.w.WriteMessage(chunkControl, 0, msgSetBandwidth, 0, d)
+		a.w.Flush()
+	}()
+	ch := readMedia(t, a, msgAckSize)
+	if v := be.Uint32(ch.Data); v != 5000 {
+		t.Fatalf("window acknowledgement size %d", v)
+	}
+	if b.w.window != 5000 {
+		t.Fatalf("peer bandwidth %d", b.w.window)
+	}
+}
+
+func TestUserControl(t *testing.T) {
+	p, q := net.Pipe()
+	a, b := NewConn(p), NewConn(q)
+	defer a.Close()
+	defer b.Close()
+	s := &Stream{conn: b, id: 1, controls: make(chan *UserControl, 16)}
+	b.str[1] = s
+	go a.Serve()
+	go b.Serve()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if rtt, err := a.Ping(ctx); err != nil || rtt <= 0 {
+		t.Fatalf("ping: %v %v", rtt, err)
+	}
+	if err := (&Stream{conn: a, id: 1}).SetBufferLength(3 * time.Second); err != nil {
+		t.Fatal("set buffer length:", err)
+	}
+	a.writeControl(ControlStreamEOF, 1)
+	a.writeControl(ControlStreamDry, 2)
+	a.writeControl(ControlStreamRecorded, 1)
+	a.w.Flush()
+	for _, it := range []UserControl{
+		{ControlSetBufferLength, 1, 3 * time.Second},
+		{ControlStreamEOF, 1, 0},
+		{ControlStreamRecorded, 1, 0},
+	} {
+		select {
+		case ev := <-s.Controls():
+			if *ev != it {
+				t.Fatalf("control: %+v != %+v", ev, it)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("control: timeout")
+		}
+	}
+}
+
+func TestInvalidChunkSize(t *testing.T) {
+	p, q := net.Pipe()
+	a, b := NewConn(p), NewConn(q)
+	defer a.Close()
+	defer b.Close()
+
+	if err := a.SetChunkSize(0); err == nil {
+		t.Fatal("set chunk size: no error")
+	}
+	go func() {
+		a.w.WriteMessage(chunkControl, 0, msgSetChunkSize, 0, []byte{0x80, 0, 0, 0})
+		a.w.Flush()
+	}()
+	if err, ok := b.Serve().(*ProtocolError); !ok {
+		t.Fatalf("serve: %v", err)
+	}
+}
+
+func TestTransactionID(t *testing.T) {
+	r := &requestMux{}
+	id, _, _ := r.newRequest()
+	if id != 1 {
+		t.Fatalf("first transaction ID %d", id)
+	}
+	r.seq = maxTransactionID - 1
+	if id, _, _ = r.newRequest(); id != maxTransactionID {
+		t.Fatalf("transaction ID %d", id)
+	}
+	// Wraps skipping zero and the pending request 1
+	if id = r.nextID(); id != 2 {
+		t.Fatalf("transaction ID %d", id)
+	}
+	r.close(io.EOF)
+	if _, _, err := r.newRequest(); err != io.EOF {
+		t.Fatal("request after close:", err)
+	}
+}
+
+func TestHandleFunc(t *testing.T) {
+	p, q := net.Pipe()
+	a, b := NewConn(p), NewConn(q)
+	defer a.Close()
+	defer b.Close()
+	calls := make(chan *Call, 1)
+	a.HandleFunc("onBWDone", func(call *Call) (interface{}, error) {
+		calls <- call
+		return nil, nil
+	})
+	a.HandleFunc("echo", func(call *Call) (interface{}, error) {
+		return call.Args[0], nil
+	})
+	a.HandleFunc("fail", func(call *Call) (interface{}, error) {
+		return nil, &StatusError{Code: "App.Failed", Description: "failed"}
+	})
+	go a.Serve()
+	go b.Serve()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	b.writeCommand(0, "onBWDone", 0, nil, 1024)
+	b.w.Flush()
+	select {
+	case call := <-calls:
+		if call.Conn != a || call.Name != "onBWDone" || call.ID != 0 || len(call.Args) != 1 || call.Args[0] != float64(1024) {
+			t.Fatalf("call: %+v", call)
+		}
+	case <-ctx.Done():
+		t.Fatal("call: timeout")
+	}
+
+	var s string
+	if err := b.Call(ctx, "echo", &s, "hello"); err != nil || s != "hello" {
+		t.Fatalf("echo: %q %v", s, err)
+	}
+	err := b.Call(ctx, "fail", nil)
+	if e, ok := err.(*StatusError); !ok || e.Level != "error" || e.Code != "App.Failed" || e.Description != "failed" {
+		t.Fatalf("fail: %#v", err)
+	}
+	err = b.Call(ctx, "unknown", nil)
+	if e, ok := err.(*StatusError); !ok || e.Code != "NetConnection.Call.Failed" {
+		t.Fatalf("unknown: %#v", err)
+	}
+}
+
+func TestStatusEvents(t *testing.T) {
+	p, q := net.Pipe()
+	a, b := NewConn(p), NewConn(q)
+	defer a.Close()
+	defer b.Close()
+	s := &Stream{conn: a, id: 1, events: make(chan *Status, 16)}
+	a.str[1] = s
+	go a.Serve()
+	go b.Serve()
+
+	expect := func(events <-chan *Status, st *Status) {
+		select {
+		case ev := <-events:
+			if ev.Level != st.Level || ev.Code != st.Code || ev.Description != st.Description || ev.Details != st.Details || ev.ClientID != st.ClientID || len(ev.Extra) != len(st.Extra) {
+				t.Fatalf("status: %+v != %+v", ev, st)
+			}
+			for k, v := range st.Extra {
+				if ev.Extra[k] != v {
+					t.Fatalf("status %v: %v != %v", k, ev.Extra[k], v)
+				}
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("status: timeout")
+		}
+	}
+	st := &Status{Level: LevelStatus, Code: NetConnectionConnectClosed}
+	b.SendStatus(st)
+	expect(a.Events(), st)
+
+	st = NewStatus(NetStreamPlayStart, "Started playing test.")
+	st.Details, st.ClientID = "test", "42"
+	st.Extra = map[string]interface{}{"isFastPlay": false}
+	(&Stream{conn: b, id: 1}).SendStatus(st)
+	expect(s.Events(), st)
+
+	// Numeric client ID is converted to the string
+	b.writeCommand(1, "onStatus", 0, nil, map[string]interface{}{"level": LevelWarning, "code": NetStreamPlayInsufficientBW, "clientid": 7})
+	b.w.Flush()
+	expect(s.Events(), &Status{Level: LevelWarning, Code: NetStreamPlayInsufficientBW, ClientID: "7"})
+}
+
+func TestPublishClosed(t *testing.T) {
+	p, q := net.Pipe()
+	a, b := NewConn(p), NewConn(q)
+	defer a.Close()
+	s := &Stream{conn: a, id: 1, events: make(chan *Status, 16)}
+	a.str[1] = s
+	go a.Serve()
+
+	go func() {
+		readCommand(t, b)
+		b.Close()
+	}()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- s.publish(context.Background(), "test", "")
+	}()
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Fatal("published on the closed connection")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("publish: timeout")
+	}
+}
+
+func TestSlowReader(t *testing.T) {
+	for _, report := range []bool{false, true} {
+		p, q := net.Pipe()
+		a, b := NewConn(p), NewConn(q)
+		a.PacketBuffer, a.ReportDrops = 2, report
+		s := &Stream{conn: a, id: 1, packets: make(chan *Packet, a.PacketBuffer)}
+		a.str[1] = s
+		go a.Serve()
+		go b.Serve()
+
+		send := func(from, to int) {
+			for i := from; i < to; i++ {
+				frame := []byte{0x27, 0x01, 0x00, 0x00, 0x00}
+				if i%4 == 0 {
+					frame[0] = 0x17
+				}
+				b.w.WriteMessage(chunkVideo, int64(i*40), msgVideo, 1, frame)
+			}
+			b.w.Flush()
+			// The connection is still served while the packets are not read
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if _, err := b.Ping(ctx); err != nil {
+				t.Fatal("ping:", err)
+			}
+		}
+		read := func(i int) {
+			if pkt, err := s.ReadPacket(); err != nil || pkt.Time != int64(i*40) {
+				t.Fatalf("packet %d: %+v %v", i, pkt, err)
+			}
+		}
+		send(0, 4)
+		read(0)
+		read(1)
+		// Frames 2 and 3 are dropped up to the key frame 4
+		send(4, 6)
+		if report {
+			if _, err := s.ReadPacket(); err != ErrSlowReader {
+				t.Fatal("read packet:", err)
+			}
+		}
+		read(4)
+		read(5)
+		a.Close()
+		b.Close()
+	}
+}
@@ -0,0 +1,213 @@
+package rtmp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/sha256"
+	"math/big"
+	"net"
+)
+
+// RTMPE handshake types, sent as the protocol version of C0 and S0.
+// Types 8 and 9 additionally encrypt signatures of C2 and S2 with XTEA and Blowfish.
+const (
+	protoPlain     = uint8(0x03)
+	protoEncrypted = uint8(0x06)
+	protoXTEA      = uint8(0x08)
+	protoBlowfish  = uint8(0x09)
+)
+
+// isEncrypted reports whether the handshake type is one of RTMPE types.
+func isEncrypted(proto uint8) bool {
+	return proto == protoEncrypted || proto == protoXTEA || proto == protoBlowfish
+}
+
+// dhPrime is the 1024-bit MODP group prime of RFC 2409 used by RTMPE key exchange.
+var dhPrime, _ = new(big.Int).SetString(
+	"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD1"+
+		"29024E088A67CC74020BBEA63B139B22514A08798E3404DD"+
+		"EF9519B3CD3A431B302B0A6DF25F14374FE1356D6D51C245"+
+		"E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED"+
+		"EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE65381"+
+		"FFFFFFFFFFFFFFFF", 16)
+
+var dhGenerator = big.NewInt(2)
+
+const dhKeySize = 128
+
+// dhKey is the Diffie-Hellman key pair exchanged in the hello messages.
+type dhKey struct {
+	priv *big.Int
+	pub  []byte
+}
+
+func newDHKey() (*dhKey, error) {
+	b := make([]byte, dhKeySize)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	priv := new(big.Int).SetBytes(b)
+	pub := new(big.Int).Exp(dhGenerator, priv, dhPrime)
+	return &dhKey{priv: priv, pub: pub.FillBytes(make([]byte, dhKeySize))}, nil
+}
+
+// secret returns the shared secret for the public key of the peer.
+func (k *dhKey) secret(peer []byte) ([]byte, error) {
+	y := new(big.Int).SetBytes(peer)
+	if y.Cmp(big.NewInt(1)) <= 0 || y.Cmp(new(big.Int).Sub(dhPrime, big.NewInt(1))) >= 0 {
+		return nil, ErrHandshake
+	}
+	s := new(big.Int).Exp(y, k.priv, dhPrime)
+	return s.FillBytes(make([]byte, dhKeySize)), nil
+}
+
+// cryptConn encrypts and decrypts the chunk stream with RC4 keys derived from the shared secret.
+type cryptConn struct {
+	net.Conn
+	in  *rc4.Cipher
+	out *rc4.Cipher
+}
+
+func newCryptConn(conn net.Conn, secret, own, peer []byte) net.Conn {
+	key := func(pub []byte) *rc4.Cipher {
+		h := hmac.New(sha256.New, secret)
+		h.Write(pub)
+		c, _ := rc4.NewCipher(h.Sum(nil)[:16])
+		// Both peers skip the key stream of the handshake size
+		b := make([]byte, handshakeSize)
+		c.XORKeyStream(b, b)
+		return c
+	}
+	return &cryptConn{Conn: conn, in: key(own), out: key(peer)}
+}
+
+func (c *cryptConn) Read(b []byte) (n int, err error) {
+	n, err = c.Conn.Read(b)
+	c.in.XORKeyStream(b[:n], b[:n])
+	return
+}
+
+func (c *cryptConn) Write(b []byte) (int, error) {
+	p := make([]byte, len(b))
+	c.out.XORKeyStream(p, b)
+	return c.Conn.Write(p)
+}
+
+// encryptSignature encrypts the signature of C2 or S2 in 8-byte blocks for RTMPE types 8 and 9.
+// The key of each block is selected by the byte of the digest key at the offset of the block.
+func encryptSignature(proto uint8, sig []byte, key []byte) {
+	for i := 0; i+8 <= len(sig); i += 8 {
+		b, id := sig[i:i+8], key[i]%15
+		switch proto {
+		case protoXTEA:
+			xtea(b, &rtmpeXTEAKeys[id])
+		case protoBlowfish:
+			l, r := newBlowfish(rtmpeBlowfishKeys[id][:]).encrypt(le.Uint32(b), le.Uint32(b[4:]))
+			le.PutUint32(b, l)
+			le.PutUint32(b[4:], r)
+		}
+	}
+}
+
+// xtea encrypts the block of 8 bytes in place with 32 rounds of XTEA, words are little-endian.
+func xtea(b []byte, k *[4]uint32) {
+	const delta = 0x9e3779b9
+	v0, v1 := le.Uint32(b), le.Uint32(b[4:])
+	var sum uint32
+	for i := 0; i < 32; i++ {
+		v0 += ((v1<<4 ^ v1>>5) + v1) ^ (sum + k[sum&3])
+		sum += delta
+		v1 += ((v0<<4 ^ v0>>5) + v0) ^ (sum + k[sum>>11&3])
+	}
+	le.PutUint32(b, v0)
+	le.PutUint32(b[4:], v1)
+}
+
+// Key tables of RTMPE signature encryption, indexed by the byte of the digest key modulo 15.
+var (
+	rtmpeXTEAKeys = [16][4]uint32{
+		{0xbff034b2, 0x11d9081f, 0xccdfb795, 0x748de732},
+		{0x086a5eb6, 0x1743090e, 0x6ef05ab8, 0xfe5a39e2},
+		{0x7b10956f, 0x76ce0521, 0x2388a73a, 0x440149a1},
+		{0xa943f317, 0xebf11bb2, 0xa691a5ee, 0x17f36339},
+		{0x7a30e00a, 0xb529e22c, 0xa087aea5, 0xc0cb79ac},
+		{0xbdce0c23, 0x2febdeff, 0x1cfaae16, 0x1123239d},
+		{0x55dd3f7b, 0x77e7e62e, 0x9bb8c499, 0xc9481ee4},
+		{0x407bb6b4, 0x71e89136, 0xa7aebf55, 0xca33b839},
+		{0xfcf6bdc3, 0xb63c3697, 0x7ce4f825, 0x04d959b2},
+		{0x28e091fd, 0x41954c4c, 0x7fb7db00, 0xe3a066f8},
+		{0x57845b76, 0x4f251b03, 0x46d45bcd, 0xa2c30d29},
+		{0x0acceef8, 0xda55b546, 0x03473452, 0x5863713b},
+		{0xb82075dc, 0xa75f1fee, 0xd84268e8, 0xa72a44cc},
+		{0x07cf6e9e, 0xa16d7b25, 0x9fa7ae6c, 0xd92f5629},
+		{0xfeb1eae4, 0x8c8c3ce1, 0x4e0064a7, 0x6a387c2a},
+		{0x893a9427, 0xcc3013a2, 0xf106385b, 0xa829f927},
+	}
+	rtmpeBlowfishKeys = [16][24]byte{
+		{
+			0x79, 0x34, 0x77, 0x4c, 0x67, 0xd1, 0x38, 0x3a, 0xdf, 0xb3, 0x56, 0xbe,
+			0x8b, 0x7b, 0xd0, 0x24, 0x38, 0xe0, 0x73, 0x58, 0x41, 0x5d, 0x69, 0x67,
+		},
+		{
+			0x46, 0xf6, 0xb4, 0xcc, 0x01, 0x93, 0xe3, 0xa1, 0x9e, 0x7d, 0x3c, 0x65,
+			0x55, 0x86, 0xfd, 0x09, 0x8f, 0xf7, 0xb3, 0xc4, 0x6f, 0x41, 0xca, 0x5c,
+		},
+		{
+			0x1a, 0xe7, 0xe2, 0xf3, 0xf9, 0x14, 0x79, 0x94, 0xc0, 0xd3, 0x97, 0x43,
+			0x08, 0x7b, 0xb3, 0x84, 0x43, 0x2f, 0x9d, 0x84, 0x3f, 0x21, 0x01, 0x9b,
+		},
+		{
+			0xd3, 0xe3, 0x54, 0xb0, 0xf7, 0x1d, 0xf6, 0x2b, 0x5a, 0x43, 0x4d, 0x04,
+			0x83, 0x64, 0x3e, 0x0d, 0x59, 0x2f, 0x61, 0xcb, 0xb1, 0x6a, 0x59, 0x0d,
+		},
+		{
+			0xc8, 0xc1, 0xe9, 0xb8, 0x16, 0x56, 0x99, 0x21, 0x7b, 0x5b, 0x36, 0xb7,
+			0xb5, 0x9b, 0xdf, 0x06, 0x49, 0x2c, 0x97, 0xf5, 0x95, 0x48, 0x85, 0x7e,
+		},
+		{
+			0xeb, 0xe5, 0xe6, 0x2e, 0xa4, 0xba, 0xd4, 0x2c, 0xf2, 0x16, 0xe0, 0x8f,
+			0x66, 0x23, 0xa9, 0x43, 0x41, 0xce, 0x38, 0x14, 0x84, 0x95, 0x00, 0x53,
+		},
+		{
+			0x66, 0xdb, 0x90, 0xf0, 0x3b, 0x4f, 0xf5, 0x6f, 0xe4, 0x9c, 0x20, 0x89,
+			0x35, 0x5e, 0xd2, 0xb2, 0xc3, 0x9e, 0x9f, 0x7f, 0x63, 0xb2, 0x28, 0x81,
+		},
+		{
+			0xbb, 0x20, 0xac, 0xed, 0x2a, 0x04, 0x6a, 0x19, 0x94, 0x98, 0x9b, 0xc8,
+			0xff, 0xcd, 0x93, 0xef, 0xc6, 0x0d, 0x56, 0xa7, 0xeb, 0x13, 0xd9, 0x30,
+		},
+		{
+			0xbc, 0xf2, 0x43, 0x82, 0x09, 0x40, 0x8a, 0x87, 0x25, 0x43, 0x6d, 0xe6,
+			0xbb, 0xa4, 0xb9, 0x44, 0x58, 0x3f, 0x21, 0x7c, 0x99, 0xbb, 0x3f, 0x24,
+		},
+		{
+			0xec, 0x1a, 0xaa, 0xcd, 0xce, 0xbd, 0x53, 0x11, 0xd2, 0xfb, 0x83, 0xb6,
+			0xc3, 0xba, 0xab, 0x4f, 0x62, 0x79, 0xe8, 0x65, 0xa9, 0x92, 0x28, 0x76,
+		},
+		{
+			0xc6, 0x0c, 0x30, 0x03, 0x91, 0x18, 0x2d, 0x7b, 0x79, 0xda, 0xe1, 0xd5,
+			0x64, 0x77, 0x9a, 0x12, 0xc5, 0xb1, 0xd7, 0x91, 0x4f, 0x96, 0x4c, 0xa3,
+		},
+		{
+			0xd7, 0x7c, 0x2a, 0xbf, 0xa6, 0xe7, 0x85, 0x7c, 0x45, 0xad, 0xff, 0x12,
+			0x94, 0xd8, 0xde, 0xa4, 0x5c, 0x3d, 0x79, 0xa4, 0x44, 0x02, 0x5d, 0x22,
+		},
+		{
+			0x16, 0x19, 0x0d, 0x81, 0x6a, 0x4c, 0xc7, 0xf8, 0xb8, 0xf9, 0x4e, 0xcd,
+			0x2c, 0x9e, 0x90, 0x84, 0xb2, 0x08, 0x25, 0x60, 0xe1, 0x1e, 0xae, 0x18,
+		},
+		{
+			0xe9, 0x7c, 0x58, 0x26, 0x1b, 0x51, 0x9e, 0x49, 0x82, 0x60, 0x61, 0xfc,
+			0xa0, 0xa0, 0x1b, 0xcd, 0xf5, 0x05, 0xd6, 0xa6, 0x6d, 0x07, 0x88, 0xa3,
+		},
+		{
+			0x2b, 0x97, 0x11, 0x8b, 0xd9, 0x4e, 0xd9, 0xdf, 0x20, 0xe3, 0x9c, 0x10,
+			0xe6, 0xa1, 0x35, 0x21, 0x11, 0xf9, 0x13, 0x0d, 0x0b, 0x24, 0x65, 0xb2,
+		},
+		{
+			0x53, 0x6a, 0x4c, 0x54, 0xac, 0x8b, 0x9b, 0xb8, 0x97, 0x29, 0xfc, 0x60,
+			0x2c, 0x5b, 0x3a, 0x85, 0x68, 0xb5, 0xaa, 0x6a, 0x44, 0xcd, 0x3f, 0xa7,
+		},
+	}
+)
@@ -0,0 +1,233 @@
+package rtmp
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ContextDialer dials network connections, it is implemented by net.Dialer.
+type ContextDialer interface {
+	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// A Dialer contains options for connecting to RTMP servers.
+type Dialer struct {
+	// NetDialer dials TCP connections, a zero net.Dialer is used if nil.
+	NetDialer ContextDialer
+
+	// TLSConfig is used for rtmps:// URLs.
+	// ServerName of the configuration is set to the host of the URL if empty.
+	TLSConfig *tls.Config
+
+	// HandshakeTimeout limits the time of establishing the connection, zero means no timeout.
+	// It covers the TCP connection, the proxy and RTMPT session setup, TLS and RTMP handshakes.
+	HandshakeTimeout time.Duration
+
+	// ConnectTimeout limits the time of connect command, zero means no timeout.
+	ConnectTimeout time.Duration
+
+	// Proxy returns the proxy URL for the URL of the server, no proxy is used if nil or the URL is nil.
+	// Supported proxy schemes are socks5, socks5h, http and https with optional username and password.
+	// Host names are resolved locally for socks5 proxies and by the proxy for other schemes.
+	// Use ProxyFromEnvironment to honour ALL_PROXY and HTTPS_PROXY environment variables.
+	Proxy func(*url.URL) (*url.URL, error)
+
+	// ClientInfo is sent by connect command, DefaultClientInfo is used if nil.
+	ClientInfo *ClientInfo
+
+	// ChunkSize is the size of chunks sent to the server, 10000 bytes if zero.
+	ChunkSize int
+
+	// AckWindowSize is the number of bytes the server sends between acknowledgements
+	// of the client, 250000 bytes if zero.
+	AckWindowSize uint32
+}
+
+// Dial is like DialContext with the background context.
+func (d *Dialer) Dial(uri string) (*Conn, error) {
+	return d.DialContext(context.Background(), uri)
+}
+
+// DialContext connects to the RTMP server, performs the handshake and connects to the application of the URL.
+// The context cancels dialing at any step, it does not affect the connection once it is established.
+func (d *Dialer) DialContext(ctx context.Context, uri string) (*Conn, error) {
+	c, err := d.dial(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	if d.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.ConnectTimeout)
+		defer cancel()
+	}
+	if _, err = c.Connect(ctx, d.ClientInfo); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// dial establishes the transport of the URL scheme, performs the handshake and starts serving the connection.
+func (d *Dialer) dial(ctx context.Context, uri string) (*Conn, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	scheme := strings.ToLower(u.Scheme)
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		host = u.Host
+	}
+	if port == "" {
+		switch scheme {
+		case "rtmp", "rtmpe":
+			port = "1935"
+		case "rtmpt":
+			port = "80"
+		case "rtmps", "rtmpts":
+			port = "443"
+		default:
+			return nil, fmt.Errorf("rtmp: unsupported scheme %v", u.Scheme)
+		}
+	}
+	addr := net.JoinHostPort(host, port)
+	if d.HandshakeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.HandshakeTimeout)
+		defer cancel()
+	}
+	var nc net.Conn
+	switch scheme {
+	case "rtmpt", "rtmpts":
+		nc, err = d.dialTunnel(ctx, u, addr, host, scheme == "rtmpts")
+	default:
+		nc, err = d.dialNet(ctx, u, addr)
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			err = contextError(ctx.Err())
+		}
+		return nil, err
+	}
+	stop := watchContext(ctx, nc)
+	if scheme == "rtmps" {
+		tc := tls.Client(nc, d.tlsConfig(host))
+		err = tc.Handshake()
+		nc = tc
+	}
+	c := NewConn(nc)
+	c.rtmpe = scheme == "rtmpe"
+	if err == nil {
+		err = clientHandshake(c)
+	}
+	if err == nil {
+		c.setAckSize(d.ackWindowSize())
+		err = c.SetChunkSize(d.chunkSize())
+	}
+	if stop() {
+		err = contextError(ctx.Err())
+	} else if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		err = ErrTimeout
+	}
+	if err != nil {
+		nc.Close()
+		return nil, err
+	}
+	c.url = u
+	go c.Serve()
+	return c, nil
+}
+
+func (d *Dialer) chunkSize() int {
+	if d.ChunkSize > 0 {
+		return d.ChunkSize
+	}
+	return clientChunkSize
+}
+
+func (d *Dialer) ackWindowSize() uint32 {
+	if d.AckWindowSize > 0 {
+		return d.AckWindowSize
+	}
+	return clientAckWindowSize
+}
+
+func (d *Dialer) dialNet(ctx context.Context, u *url.URL, addr string) (net.Conn, error) {
+	nd := d.NetDialer
+	if nd == nil {
+		nd = &net.Dialer{}
+	}
+	if d.Proxy != nil {
+		proxy, err := d.Proxy(u)
+		if err != nil {
+			return nil, err
+		}
+		if proxy != nil {
+			return dialProxy(ctx, nd, proxy, addr)
+		}
+	}
+	return nd.DialContext(ctx, "tcp", addr)
+}
+
+// dialTunnel opens RTMPT session over HTTP or HTTPS connections of the net dialer.
+func (d *Dialer) dialTunnel(ctx context.Context, u *url.URL, addr, host string, secure bool) (net.Conn, error) {
+	nd := d.NetDialer
+	if nd == nil {
+		nd = &net.Dialer{}
+	}
+	tr := &http.Transport{
+		DialContext:     nd.DialContext,
+		TLSClientConfig: d.tlsConfig(host),
+	}
+	if d.Proxy != nil {
+		tr.Proxy = func(*http.Request) (*url.URL, error) {
+			return d.Proxy(u)
+		}
+	}
+	client := &http.Client{Transport: tr}
+	return dialTunnel(ctx, client, addr, secure)
+}
+
+func (d *Dialer) tlsConfig(host string) *tls.Config {
+	config := d.TLSConfig
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName = host
+	}
+	return config
+}
+
+// watchContext interrupts I/O of the connection when the context is done.
+// The returned function stops watching and reports whether the I/O was interrupted.
+func watchContext(ctx context.Context, c net.Conn) func() bool {
+	done, result := make(chan struct{}), make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.SetDeadline(time.Unix(1, 0))
+			result <- true
+		case <-done:
+			result <- false
+		}
+	}()
+	return func() bool {
+		close(done)
+		return <-result
+	}
+}
+
+func contextError(err error) error {
+	if err == context.DeadlineExceeded {
+		return ErrTimeout
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+package rtmp
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+type countDialer struct {
+	net.Dialer
+	count int
+}
+
+func (d *countDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.count++
+	return d.Dialer.DialContext(ctx, network, addr)
+}
+
+func TestDialer(t *testing.T) {
+	uri := listenTestServer(t, &Server{Handler: rejectHandler{}})
+	nd := &countDialer{}
+	d := &Dialer{NetDialer: nd, HandshakeTimeout: 5 * time.Second, ConnectTimeout: 5 * time.Second}
+	c, err := d.DialContext(context.Background(), uri)
+	if err != nil {
+		t.Fatal("dial:", err)
+	}
+	defer c.Close()
+	if c.app != "live" || nd.count != 1 {
+		t.Fatalf("dial: app %q, dialed %d times", c.app, nd.count)
+	}
+	if c.w.size != 10000 || c.ackSize != 250000 {
+		t.Fatalf("dial: chunk size %d, window %d", c.w.size, c.ackSize)
+	}
+
+	d.ChunkSize, d.AckWindowSize = 4000, 100000
+	c, err = d.DialContext(context.Background(), uri)
+	if err != nil {
+		t.Fatal("dial:", err)
+	}
+	defer c.Close()
+	if c.w.size != 4000 || c.ackSize != 100000 {
+		t.Fatalf("dial: chunk size %d, window %d", c.w.size, c.ackSize)
+	}
+
+	d.ClientInfo = &ClientInfo{App: "other"}
+	if _, err = d.DialContext(context.Background(), uri); err == nil {
+		t.Fatal("dial: connect is not rejected")
+	}
+}
+
+// listenSilent accepts connections and never responds.
+func listenSilent(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen:", err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer c.Close()
+		}
+	}()
+	t.Cleanup(func() { l.Close() })
+	return "rtmp://" + l.Addr().String() + "/live"
+}
+
+func TestDialerTimeout(t *testing.T) {
+	uri := listenSilent(t)
+	d := &Dialer{HandshakeTimeout: 50 * time.Millisecond}
+	if _, err := d.Dial(uri); err != ErrTimeout {
+		t.Fatal("dial:", err)
+	}
+	// The timeout covers RTMPT session setup
+	if _, err := d.Dial("rtmpt" + strings.TrimPrefix(uri, "rtmp")); err != ErrTimeout {
+		t.Fatal("dial rtmpt:", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, err := (&Dialer{}).DialContext(ctx, uri); err != context.Canceled {
+		t.Fatal("dial:", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := (&Dialer{}).DialContext(ctx, uri); err != ErrTimeout {
+		t.Fatal("dial:", err)
+	}
+}
@@ -0,0 +1,67 @@
+package rtmp
+
+// A Handler decides on connect, publish, play and call requests of server connections.
+// Returning a non-nil error rejects the request, a *StatusError sets the status code and description sent to the client.
+// Handlers may rewrite the application and stream names by modifying the request.
+type Handler interface {
+	OnConnect(c *Conn, info *ClientInfo) error
+	OnPublish(c *Conn, req *PublishRequest) error
+	OnPlay(c *Conn, req *PlayRequest) error
+	OnCall(c *Conn, name string, args []interface{}) (interface{}, error)
+	OnClose(c *Conn)
+}
+
+// PublishRequest represents the publish command.
+type PublishRequest struct {
+	App  string
+	Name string
+	Type string // live, record or append
+}
+
+// PlayRequest represents the play command.
+type PlayRequest struct {
+	App      string
+	Name     string
+	Start    float64
+	Duration float64
+	Reset    bool
+}
+
+// NopHandler accepts all requests and ignores calls.
+// It can be embedded to implement only some of the Handler methods.
+type NopHandler struct{}
+
+func (NopHandler) OnConnect(c *Conn, info *ClientInfo) error    { return nil }
+func (NopHandler) OnPublish(c *Conn, req *PublishRequest) error { return nil }
+func (NopHandler) OnPlay(c *Conn, req *PlayRequest) error       { return nil }
+func (NopHandler) OnClose(c *Conn)                              {}
+func (NopHandler) OnCall(c *Conn, name string, args []interface{}) (interface{}, error) {
+	return nil, nil
+}
+
+// StatusError represents the error status sent to or received from the peer.
+// Level is "error" if empty.
+type StatusError struct {
+	Level       string
+	Code        string
+	Description string
+}
+
+func (err *StatusError) Error() string {
+	if err.Description == "" {
+		return "rtmp: " + err.Code
+	}
+	return "rtmp: " + err.Code + ": " + err.Description
+}
+
+// errorStatus returns the error status for err using the code if err is not a *StatusError.
+func errorStatus(err error, code string) *Status {
+	if e, ok := err.(*StatusError); ok {
+		st := newError(e.Code, e.Description)
+		if e.Level != "" {
+			st.Level = e.Level
+		}
+		return st
+	}
+	return newError(code, err.Error())
+}
@@ -6,78 +6,126 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"errors"
+	"io"
+	"time"
 )
 
 var ErrHandshake = errors.New("rtmp: handshake error")
 
+const handshakeSize = 1536
+
+// Versions sent in the digest handshake, the simple handshake uses zero version.
+const (
+	clientVersion = uint32(0x09007c02)
+	serverVersion = uint32(0x04050001)
+)
+
+var keySuffix = []byte{
+	0xf0, 0xee, 0xc2, 0x4a, 0x80, 0x68, 0xbe, 0xe8, 0x2e, 0x00, 0xd0, 0xd1,
+	0x02, 0x9e, 0x7e, 0x57, 0x6e, 0xec, 0x5d, 0x2d, 0x29, 0x80, 0x6f, 0xab,
+	0x93, 0xb8, 0xe6, 0x36, 0xcf, 0xeb, 0x31, 0xae,
+}
+
+// Keys of the digest handshake, the hello messages are signed by the text part of the key only.
+var (
+	clientKey = append([]byte("Genuine Adobe Flash Player 001"), keySuffix...)
+	serverKey = append([]byte("Genuine Adobe Flash Media Server 001"), keySuffix...)
+)
+
 type handshakeHello struct {
 	Proto   uint8
 	Time    uint32
 	Version uint32
+	Scheme  int
 	Digest  []byte // 32 bytes
 	PubKey  []byte
 }
 
+// pack writes C0 and C1 or S0 and S1 messages signed with the key, if not nil.
 func (h *handshakeHello) pack(b []byte, key []byte) []byte {
 	b[0] = h.Proto
-	be.PutUint32(b[1:], h.Time)
-	be.PutUint32(b[5:], h.Version)
-	rand.Read(b[9:1537])
-	return b[:1537]
+	p := b[1 : 1+handshakeSize]
+	be.PutUint32(p, h.Time)
+	be.PutUint32(p[4:], h.Version)
+	rand.Read(p[8:])
+	if key != nil {
+		if h.PubKey != nil {
+			copy(p[keyOffset(p, h.Scheme):], h.PubKey)
+		}
+		off := digestOffset(p, h.Scheme)
+		h.Digest = digest(p, key, off)
+		copy(p[off:], h.Digest)
+	}
+	return b[:1+handshakeSize]
 }
 
+// unpack reads the hello message and finds the digest signed with the key in both schemes.
+// Digest is nil if the peer uses the simple handshake.
 func (h *handshakeHello) unpack(b []byte, key []byte) {
 	h.Proto = b[0]
-	h.Time = be.Uint32(b[1:])
-	h.Version = be.Uint32(b[5:])
-	//if !h.unpackDigest(b, key, h.offset(b, 772) % 728 + 776, 768) {
-	//	h.unpackDigest(b, key, h.offset(b, 8) % 728 + 12, 1532)
-	//}
+	p := b[1 : 1+handshakeSize]
+	h.Time = be.Uint32(p)
+	h.Version = be.Uint32(p[4:])
+	if key == nil || h.Version == 0 {
+		return
+	}
+	for scheme := 0; scheme < 2; scheme++ {
+		off := digestOffset(p, scheme)
+		if dig := digest(p, key, off); hmac.Equal(p[off:off+32], dig) {
+			h.Scheme, h.Digest = scheme, dig
+			off = keyOffset(p, scheme)
+			h.PubKey = make([]byte, 128)
+			copy(h.PubKey, p[off:off+128])
+			return
+		}
+	}
 }
 
-func (h *handshakeHello) unpackDigest(b []byte, key []byte, off int, pos int) bool {
-	if 0 <= off && off+32 <= len(b) {
-		dig := b[off : off+32]
-		crc := h.digest(b, key, off)
-		if bytes.Compare(dig, crc) == 0 {
-			h.Digest = crc
-			off = h.offset(b, pos)
-			if 0 < off && off+128 <= len(b) {
-				h.PubKey = make([]byte, 128)
-				copy(h.PubKey, b[off:off+128])
-			}
-			return true
-		}
+// digestOffset returns the offset of the digest in the hello message.
+// Scheme 0 places the digest before the public key, scheme 1 after it.
+func digestOffset(p []byte, scheme int) int {
+	if scheme == 0 {
+		return sumOffset(p, 8)%728 + 12
+	}
+	return sumOffset(p, 772)%728 + 776
+}
+
+// keyOffset returns the offset of the Diffie-Hellman public key in the hello message.
+func keyOffset(p []byte, scheme int) int {
+	if scheme == 0 {
+		return sumOffset(p, 1532)%632 + 772
 	}
-	return false
+	return sumOffset(p, 768)%632 + 8
 }
 
-func (h *handshakeHello) offset(b []byte, off int) int {
-	return int(b[off]) + int(b[off+1]) + int(b[off+2]) + int(b[off+3])
+func sumOffset(p []byte, off int) int {
+	return int(p[off]) + int(p[off+1]) + int(p[off+2]) + int(p[off+3])
 }
 
-func (h *handshakeHello) digest(b []byte, key []byte, off int) []byte {
+// digest returns HMAC-SHA256 of the message excluding the digest at the offset.
+func digest(p []byte, key []byte, off int) []byte {
 	r := hmac.New(sha256.New, key)
-	if off > 0 {
-		r.Write(b[:off])
-	}
-	if off+32 < len(b) {
-		r.Write(b[off+32:])
-	}
+	r.Write(p[:off])
+	r.Write(p[off+32:])
 	return r.Sum(nil)
 }
 
 type handshakeAck struct {
+	Proto    uint8 // handshake type, RTMPE types 8 and 9 encrypt the signature
 	Time     uint32
 	RecvTime uint32
-	Digest   []byte
+	Digest   []byte // digest of the hello message of the peer
 }
 
-func (a *handshakeAck) pack(b []byte) []byte {
+// pack writes C2 or S2 message signed for the digest of the peer with the full key, if not nil.
+func (a *handshakeAck) pack(b []byte, key []byte) []byte {
 	be.PutUint32(b, a.Time)
 	be.PutUint32(b[4:], a.RecvTime)
-	rand.Read(b[8:1536])
-	return b[:1536]
+	rand.Read(b[8:handshakeSize])
+	if key != nil && a.Digest != nil {
+		copy(b[handshakeSize-32:], ackDigest(b, key, a.Digest, a.Proto))
+	}
+	return b[:handshakeSize]
 }
 
 func (a *handshakeAck) unpack(b []byte) {
@@ -85,36 +133,116 @@ func (a *handshakeAck) unpack(b []byte) {
 	a.RecvTime = be.Uint32(b[4:])
 }
 
-func serverHandshake(c *Conn) (err error) {
-	/*b := make([]byte, 3073)
-	b[0] = 0x03
-	ct, cv := uint32(wallclock()), uint32(0x10000000)
-	be.PutUint32(b[1:], ct)
-	be.PutUint32(b[5:], cv)
-	rand.Read(b[9:1537])
-	if _, err = c.Write(b); err != nil {
+// ackDigest returns the signature of the response to the hello message with the digest,
+// encrypted as required by the handshake type.
+func ackDigest(p []byte, key []byte, dig []byte, proto uint8) []byte {
+	r := hmac.New(sha256.New, key)
+	r.Write(dig)
+	k := r.Sum(nil)
+	r = hmac.New(sha256.New, k)
+	r.Write(p[:handshakeSize-32])
+	sig := r.Sum(nil)
+	encryptSignature(proto, sig, k)
+	return sig
+}
+
+// verifyAck checks that the response is signed for the digest of the hello message
+// or echoes the hello message as in the simple handshake.
+func verifyAck(p []byte, key []byte, hello []byte, dig []byte, proto uint8) bool {
+	if dig != nil && hmac.Equal(p[handshakeSize-32:handshakeSize], ackDigest(p, key, dig, proto)) {
+		return true
+	}
+	return bytes.Equal(p[8:handshakeSize], hello[8:handshakeSize])
+}
+
+// clientHandshake performs the digest handshake with fallback to the simple handshake
+// if the server does not sign S1. RTMPE requires the digest handshake to exchange the keys,
+// the client requests type 6 and follows the server answering with type 8 or 9.
+func clientHandshake(c *Conn) (err error) {
+	ch := &handshakeHello{Proto: protoPlain, Version: clientVersion}
+	var dh *dhKey
+	if c.rtmpe {
+		if dh, err = newDHKey(); err != nil {
+			return
+		}
+		ch.Proto, ch.PubKey = protoEncrypted, dh.pub
+	}
+	hello := ch.pack(make([]byte, 1+handshakeSize), clientKey[:30])
+	ts := time.Now()
+	if _, err = c.Write(hello); err != nil {
+		return
+	}
+	b := make([]byte, 1+2*handshakeSize)
+	if _, err = io.ReadFull(c.Conn, b); err != nil {
+		return
+	}
+	rt := time.Since(ts)
+	sh := &handshakeHello{}
+	sh.unpack(b, serverKey[:36])
+	if dh == nil && sh.Proto != ch.Proto || dh != nil && (!isEncrypted(sh.Proto) || sh.Digest == nil) {
+		return ErrHandshake
+	}
+	s2 := b[1+handshakeSize:]
+	if sh.Digest != nil && !verifyAck(s2, serverKey, hello[1:], ch.Digest, sh.Proto) {
+		return ErrHandshake
+	}
+	ca := &handshakeAck{
+		Proto:    sh.Proto,
+		Time:     sh.Time,
+		RecvTime: uint32(rt / time.Millisecond),
+		Digest:   sh.Digest,
+	}
+	if sh.Digest == nil {
+		// Echo S1 as C2
+		p := b[1 : 1+handshakeSize]
+		be.PutUint32(p[4:], ca.RecvTime)
+		_, err = c.Write(p)
 		return
 	}
+	if _, err = c.Write(ca.pack(s2, clientKey)); err != nil || dh == nil {
+		return
+	}
+	return c.encrypt(dh, sh.PubKey)
+}
 
-	if _, err = c.Read(b[:1537]); err != nil {
+// serverHandshake performs the digest handshake if the client signs C1 or the simple handshake otherwise.
+// RTMPE handshake is accepted only if it is allowed by the server.
+func serverHandshake(c *Conn) (err error) {
+	b := make([]byte, 1+2*handshakeSize)
+	if _, err = io.ReadFull(c.Conn, b[:1+handshakeSize]); err != nil {
 		return
 	}
-	if b[0] != 0x03 {
+	ch := &handshakeHello{}
+	ch.unpack(b, clientKey[:30])
+	sh := &handshakeHello{Proto: ch.Proto}
+	var dh *dhKey
+	switch {
+	case ch.Proto == protoPlain:
+	case isEncrypted(ch.Proto) && ch.Digest != nil && c.server != nil && c.server.AllowEncryption:
+		if dh, err = newDHKey(); err != nil {
+			return
+		}
+		sh.PubKey = dh.pub
+	default:
 		return ErrHandshake
 	}
-	ct, _ := getUint32(b[1:]), getUint32(b[5:])
-	st, sv := uint32(wallclock()), uint32(0x10000000)
-	be.PutUint32(b[1:], st)
-	be.PutUint32(b[5:], sv)
-	be.PutUint32(b[1537:], st)
-	be.PutUint32(b[1541:], ct)
-	rand.Read(b[1545:])
+	s2 := b[1+handshakeSize:]
+	if ch.Digest != nil {
+		sh.Version, sh.Scheme = serverVersion, ch.Scheme
+		sa := &handshakeAck{Proto: ch.Proto, Time: ch.Time, Digest: ch.Digest}
+		sa.pack(s2, serverKey)
+		sh.pack(b, serverKey[:36])
+	} else {
+		// Echo C1 as S2
+		copy(s2, b[1:1+handshakeSize])
+		be.PutUint32(s2[4:], uint32(time.Now().UnixNano()/int64(time.Millisecond)))
+		sh.pack(b, nil)
+	}
 	if _, err = c.Write(b); err != nil {
 		return
 	}
-	if _, err = c.Read(b[:1537]); err != nil {
+	if _, err = io.ReadFull(c.Conn, b[:handshakeSize]); err != nil || dh == nil {
 		return
 	}
-	return*/
-	return nil
+	return c.encrypt(dh, ch.PubKey)
 }
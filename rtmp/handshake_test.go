@@ -0,0 +1,300 @@
+package rtmp
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func TestHandshakeDigest(t *testing.T) {
+	for scheme := 0; scheme < 2; scheme++ {
+		pub := bytes.Repeat([]byte{byte(scheme + 1)}, 128)
+		h := &handshakeHello{Proto: 3, Version: clientVersion, Scheme: scheme, PubKey: pub}
+		b := h.pack(make([]byte, 1+handshakeSize), clientKey[:30])
+
+		r := &handshakeHello{}
+		r.unpack(b, clientKey[:30])
+		if r.Scheme != scheme || !bytes.Equal(r.Digest, h.Digest) || !bytes.Equal(r.PubKey, pub) {
+			t.Fatalf("scheme %d: %+v", scheme, r)
+		}
+		r = &handshakeHello{}
+		if r.unpack(b, serverKey[:36]); r.Digest != nil {
+			t.Fatalf("scheme %d: digest with wrong key", scheme)
+		}
+	}
+}
+
+// Handshakes between librtmp 2.4 client and server, see testdata/librtmp/README.md.
+// The rtmpe8 server answers the type 6 request of the client with type 8.
+// In rtmpe9 only S0 is patched to type 9, so C2 is signed as type 9 and S2 as type 8.
+var handshakeVectors = []struct {
+	file          string
+	scheme        int
+	proto, proto2 uint8 // handshake types of S2 and C2 signatures
+}{
+	{"handshake_librtmp_fp9.bin", 0, protoPlain, protoPlain},
+	{"handshake_librtmp_rtmpe8.bin", 1, protoXTEA, protoXTEA},
+	{"handshake_librtmp_rtmpe9.bin", 1, protoXTEA, protoBlowfish},
+}
+
+func readHandshake(t *testing.T, file string) (c01, s012, c2 []byte) {
+	b, err := ioutil.ReadFile("testdata/" + file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) != 2+4*handshakeSize {
+		t.Fatalf("%s: size %d", file, len(b))
+	}
+	return b[:1+handshakeSize], b[1+handshakeSize : 2+3*handshakeSize], b[2+3*handshakeSize:]
+}
+
+func TestHandshakeVectors(t *testing.T) {
+	for _, v := range handshakeVectors {
+		c01, s012, c2 := readHandshake(t, v.file)
+		ch, sh := &handshakeHello{}, &handshakeHello{}
+		ch.unpack(c01, clientKey[:30])
+		sh.unpack(s012, serverKey[:36])
+		if ch.Digest == nil || ch.Scheme != v.scheme {
+			t.Fatalf("%s: client hello %+v", v.file, ch)
+		}
+		if sh.Digest == nil || sh.Scheme != v.scheme {
+			t.Fatalf("%s: server hello %+v", v.file, sh)
+		}
+		if isEncrypted(ch.Proto) && (ch.PubKey == nil || sh.PubKey == nil) {
+			t.Fatalf("%s: no public keys", v.file)
+		}
+		s2 := s012[1+handshakeSize:]
+		if !verifyAck(s2, serverKey, c01[1:], ch.Digest, v.proto) {
+			t.Fatalf("%s: S2 is not verified", v.file)
+		}
+		if !verifyAck(c2, clientKey, s012[1:], sh.Digest, v.proto2) {
+			t.Fatalf("%s: C2 is not verified", v.file)
+		}
+		if v.proto != protoPlain && verifyAck(s2, serverKey, c01[1:], ch.Digest, protoPlain) {
+			t.Fatalf("%s: S2 is verified without encryption", v.file)
+		}
+	}
+}
+
+func TestHandshakeVectorSimple(t *testing.T) {
+	c01, s012, c2 := readHandshake(t, "handshake_librtmp_simple.bin")
+	ch, sh := &handshakeHello{}, &handshakeHello{}
+	ch.unpack(c01, clientKey[:30])
+	sh.unpack(s012, serverKey[:36])
+	if ch.Version != 0 || ch.Digest != nil || sh.Digest != nil {
+		t.Fatalf("digest in simple handshake: %+v %+v", ch, sh)
+	}
+	if !verifyAck(s012[1+handshakeSize:], serverKey, c01[1:], nil, protoPlain) {
+		t.Fatal("S2 does not echo C1")
+	}
+	if !verifyAck(c2, clientKey, s012[1:], nil, protoPlain) {
+		t.Fatal("C2 does not echo S1")
+	}
+}
+
+func testHandshake(t *testing.T, client func(c *Conn) error, server func(c *Conn) error) {
+	p, q := net.Pipe()
+	defer p.Close()
+	defer q.Close()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- server(NewConn(q))
+	}()
+	if err := client(NewConn(p)); err != nil {
+		t.Fatal("client handshake:", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal("server handshake:", err)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	testHandshake(t, clientHandshake, serverHandshake)
+}
+
+func TestHandshakeChunkSize(t *testing.T) {
+	p, q := net.Pipe()
+	defer p.Close()
+	defer q.Close()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- NewConn(p).Handshake()
+	}()
+	b := NewConn(q)
+	if err := serverHandshake(b); err != nil {
+		t.Fatal("server handshake:", err)
+	}
+	for _, exp := range []struct {
+		typ uint8
+		v   uint32
+	}{{msgAckSize, 250000}, {msgSetChunkSize, 10000}} {
+		ch, err := b.r.ReadChunk()
+		if err != nil {
+			t.Fatal("read chunk:", err)
+		}
+		if ch.Type != exp.typ || be.Uint32(ch.Data) != exp.v {
+			t.Fatalf("chunk %d: %d != %d", ch.Type, be.Uint32(ch.Data), exp.v)
+		}
+	}
+	if err := <-errc; err != nil {
+		t.Fatal("client handshake:", err)
+	}
+}
+
+func TestHandshakeSimpleClient(t *testing.T) {
+	var s1 []byte
+	testHandshake(t, func(c *Conn) error {
+		h := &handshakeHello{Proto: 3}
+		c1 := h.pack(make([]byte, 1+handshakeSize), nil)
+		c.Write(c1)
+		b := make([]byte, 1+2*handshakeSize)
+		if _, err := io.ReadFull(c, b); err != nil {
+			return err
+		}
+		s1 = b[1 : 1+handshakeSize]
+		sh := &handshakeHello{}
+		if sh.unpack(b, serverKey[:36]); sh.Version != 0 || sh.Digest != nil {
+			t.Fatalf("server hello: %+v", sh)
+		}
+		if !verifyAck(b[1+handshakeSize:], serverKey, c1[1:], nil, protoPlain) {
+			t.Fatal("server ack: C1 is not echoed")
+		}
+		_, err := c.Write(s1)
+		return err
+	}, serverHandshake)
+}
+
+func TestHandshakeSimpleServer(t *testing.T) {
+	testHandshake(t, clientHandshake, func(c *Conn) error {
+		b := make([]byte, 1+2*handshakeSize)
+		if _, err := io.ReadFull(c, b[:1+handshakeSize]); err != nil {
+			return err
+		}
+		ch := &handshakeHello{}
+		if ch.unpack(b, clientKey[:30]); ch.Digest == nil {
+			t.Fatal("client hello: no digest")
+		}
+		copy(b[1+handshakeSize:], b[1:1+handshakeSize])
+		h := &handshakeHello{Proto: 3}
+		h.pack(b, nil)
+		s1 := append([]byte{}, b[1:1+handshakeSize]...)
+		c.Write(b)
+		if _, err := io.ReadFull(c, b[:handshakeSize]); err != nil {
+			return err
+		}
+		if !bytes.Equal(b[8:handshakeSize], s1[8:]) {
+			t.Fatal("client ack: S1 is not echoed")
+		}
+		return nil
+	})
+}
+
+func TestHandshakeEncrypted(t *testing.T) {
+	p, q := net.Pipe()
+	defer p.Close()
+	defer q.Close()
+	a, b := NewConn(p), NewConn(q)
+	a.rtmpe = true
+	b.server = &Server{AllowEncryption: true}
+	errc := make(chan error, 1)
+	go func() {
+		errc <- serverHandshake(b)
+	}()
+	if err := clientHandshake(a); err != nil {
+		t.Fatal("client handshake:", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal("server handshake:", err)
+	}
+	if _, ok := a.Conn.(*cryptConn); !ok {
+		t.Fatal("client connection is not encrypted")
+	}
+	data := []byte("encrypted message")
+	for _, it := range [][2]*Conn{{a, b}, {b, a}} {
+		go func(c *Conn) {
+			c.w.WriteMessage(chunkCommand, 0, msgAmf0Command, 0, data)
+			c.w.Flush()
+		}(it[0])
+		ch, err := it[1].r.ReadChunk()
+		if err != nil || !bytes.Equal(ch.Data, data) {
+			t.Fatalf("read chunk: %+v %v", ch, err)
+		}
+	}
+}
+
+func TestHandshakeEncryptedTypes(t *testing.T) {
+	for _, proto := range []uint8{protoXTEA, protoBlowfish} {
+		p, q := net.Pipe()
+		b := NewConn(q)
+		b.server = &Server{AllowEncryption: true}
+		errc := make(chan error, 1)
+		go func() {
+			errc <- serverHandshake(b)
+		}()
+		dh, _ := newDHKey()
+		ch := &handshakeHello{Proto: proto, Version: clientVersion, PubKey: dh.pub}
+		c1 := ch.pack(make([]byte, 1+handshakeSize), clientKey[:30])
+		p.Write(c1)
+		s := make([]byte, 1+2*handshakeSize)
+		if _, err := io.ReadFull(p, s); err != nil {
+			t.Fatal("read:", err)
+		}
+		sh := &handshakeHello{}
+		if sh.unpack(s, serverKey[:36]); sh.Proto != proto || sh.Digest == nil {
+			t.Fatalf("type %d: server hello %+v", proto, sh)
+		}
+		s2 := s[1+handshakeSize:]
+		if !verifyAck(s2, serverKey, c1[1:], ch.Digest, proto) || verifyAck(s2, serverKey, c1[1:], ch.Digest, protoEncrypted) {
+			t.Fatalf("type %d: server signature is not encrypted", proto)
+		}
+		ca := &handshakeAck{Proto: proto, Time: sh.Time, Digest: sh.Digest}
+		p.Write(ca.pack(make([]byte, handshakeSize), clientKey))
+		if err := <-errc; err != nil {
+			t.Fatalf("type %d: server handshake: %v", proto, err)
+		}
+		p.Close()
+	}
+}
+
+func TestBlowfish(t *testing.T) {
+	// Test vector of Eric Young for the zero key and block
+	if l, r := newBlowfish(make([]byte, 8)).encrypt(0, 0); l != 0x4ef99745 || r != 0x6198dd78 {
+		t.Fatalf("encrypt: %08x%08x", l, r)
+	}
+}
+
+func TestHandshakeEncryptedNotAllowed(t *testing.T) {
+	p, q := net.Pipe()
+	defer p.Close()
+	a, b := NewConn(p), NewConn(q)
+	a.rtmpe = true
+	b.server = &Server{}
+	go func() {
+		serverHandshake(b)
+		q.Close()
+	}()
+	if err := clientHandshake(a); err == nil {
+		t.Fatal("client handshake: no error")
+	}
+}
+
+func TestDHKey(t *testing.T) {
+	a, err := newDHKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := newDHKey()
+	s1, err := a.secret(b.pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, _ := b.secret(a.pub)
+	if !bytes.Equal(s1, s2) || len(s1) != dhKeySize {
+		t.Fatal("shared secrets differ")
+	}
+	if _, err = a.secret([]byte{1}); err == nil {
+		t.Fatal("invalid public key accepted")
+	}
+}
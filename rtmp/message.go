@@ -1,5 +1,7 @@
 package rtmp
 
+import "time"
+
 const (
 	msgSetChunkSize = uint8(0x01)
 	msgAbort        = uint8(0x02)
@@ -21,21 +23,39 @@ const (
 )
 
 const (
-	ctrlStreamBegin      = uint8(0x00)
-	ctrlStreamEOF        = uint8(0x01)
-	ctrlStreamDry        = uint8(0x02)
-	ctrlStreamSetBuffer  = uint8(0x03)
-	ctrlStreamIsRecorded = uint8(0x04)
-	ctrlPingRequest      = uint8(0x06)
-	ctrlPingResponse     = uint8(0x07)
+	chunkControl = uint32(0x02)
+	chunkCommand = uint32(0x03)
+	chunkAudio   = uint32(0x04)
+	chunkData    = uint32(0x05)
+	chunkVideo   = uint32(0x06)
+)
+
+// User control event types.
+const (
+	ControlStreamBegin     = uint8(0x00)
+	ControlStreamEOF       = uint8(0x01)
+	ControlStreamDry       = uint8(0x02)
+	ControlSetBufferLength = uint8(0x03)
+	ControlStreamRecorded  = uint8(0x04)
+	ControlPingRequest     = uint8(0x06)
+	ControlPingResponse    = uint8(0x07)
 )
 
+// UserControl represents the user control event of the stream.
+// BufferLength is set for ControlSetBufferLength event.
+type UserControl struct {
+	Type         uint8
+	Stream       uint32
+	BufferLength time.Duration
+}
+
 const (
 	limitHard    = uint8(0x00)
 	limitSoft    = uint8(0x01)
 	limitDynamic = uint8(0x02)
 )
 
+// ClientInfo represents the command object of connect command.
 type ClientInfo struct {
 	App          string `amf:"app"`
 	FlashVer     string `amf:"flashVer"`
@@ -0,0 +1,271 @@
+package rtmp
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ProxyURL returns the proxy function for Dialer that always returns the proxy URL.
+func ProxyURL(proxy *url.URL) func(*url.URL) (*url.URL, error) {
+	return func(*url.URL) (*url.URL, error) {
+		return proxy, nil
+	}
+}
+
+// ProxyFromEnvironment returns the proxy URL from HTTPS_PROXY for rtmps and rtmpts URLs
+// and from ALL_PROXY otherwise, the lowercase names are used if not set.
+// Hosts listed in NO_PROXY are connected directly.
+func ProxyFromEnvironment(u *url.URL) (*url.URL, error) {
+	var proxy string
+	switch strings.ToLower(u.Scheme) {
+	case "rtmps", "rtmpts":
+		proxy = getEnv("HTTPS_PROXY")
+	}
+	if proxy == "" {
+		proxy = getEnv("ALL_PROXY")
+	}
+	if proxy == "" || noProxy(u.Hostname()) {
+		return nil, nil
+	}
+	p, err := url.Parse(proxy)
+	if err != nil || p.Host == "" {
+		// Proxy may be specified without scheme
+		if p, err = url.Parse("http://" + proxy); err != nil {
+			return nil, fmt.Errorf("rtmp: invalid proxy address %q: %v", proxy, err)
+		}
+	}
+	return p, nil
+}
+
+func getEnv(name string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return os.Getenv(strings.ToLower(name))
+}
+
+func noProxy(host string) bool {
+	for _, it := range strings.Split(getEnv("NO_PROXY"), ",") {
+		it = strings.TrimPrefix(strings.TrimSpace(it), ".")
+		if it == "*" || it != "" && (host == it || strings.HasSuffix(host, "."+it)) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialProxy connects to the address through the proxy.
+func dialProxy(ctx context.Context, nd ContextDialer, proxy *url.URL, addr string) (c net.Conn, err error) {
+	port := proxy.Port()
+	switch proxy.Scheme {
+	case "socks5", "socks5h":
+		if port == "" {
+			port = "1080"
+		}
+	case "http":
+		if port == "" {
+			port = "80"
+		}
+	case "https":
+		if port == "" {
+			port = "443"
+		}
+	default:
+		return nil, fmt.Errorf("rtmp: unsupported proxy scheme %v", proxy.Scheme)
+	}
+	if c, err = nd.DialContext(ctx, "tcp", net.JoinHostPort(proxy.Hostname(), port)); err != nil {
+		return
+	}
+	stop := watchContext(ctx, c)
+	switch proxy.Scheme {
+	case "socks5":
+		if addr, err = resolveAddr(ctx, addr); err == nil {
+			err = socksConnect(c, proxy.User, addr)
+		}
+	case "socks5h":
+		err = socksConnect(c, proxy.User, addr)
+	case "https":
+		c = tls.Client(c, &tls.Config{ServerName: proxy.Hostname()})
+		fallthrough
+	default:
+		c, err = httpConnect(c, proxy.User, addr)
+	}
+	if stop() {
+		err = contextError(ctx.Err())
+	}
+	if err != nil {
+		if c != nil {
+			c.Close()
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// resolveAddr resolves the host name of the address locally, preferring IPv4 addresses.
+// Proxies of the socks5 scheme are sent the IP address, only socks5h proxies resolve the host name.
+func resolveAddr(ctx context.Context, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return addr, err
+	}
+	ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	if len(ips) == 0 {
+		return "", fmt.Errorf("rtmp: no addresses for %v", host)
+	}
+	ip := ips[0].IP
+	for _, it := range ips {
+		if it.IP.To4() != nil {
+			ip = it.IP
+			break
+		}
+	}
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+var errSocks = errors.New("rtmp: socks5 proxy error")
+
+// socksConnect performs SOCKS5 CONNECT request with username and password authentication if the user is set.
+func socksConnect(c net.Conn, user *url.Userinfo, addr string) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return err
+	}
+	b := []byte{5, 1, 0}
+	if user != nil {
+		b = []byte{5, 2, 0, 2}
+	}
+	if _, err = c.Write(b); err != nil {
+		return err
+	}
+	if _, err = io.ReadFull(c, b[:2]); err != nil {
+		return err
+	}
+	switch {
+	case b[0] != 5:
+		return errSocks
+	case b[1] == 2 && user != nil:
+		name := user.Username()
+		pass, _ := user.Password()
+		if len(name) > 255 || len(pass) > 255 {
+			return errSocks
+		}
+		b = append([]byte{1, byte(len(name))}, name...)
+		b = append(append(b, byte(len(pass))), pass...)
+		if _, err = c.Write(b); err != nil {
+			return err
+		}
+		if _, err = io.ReadFull(c, b[:2]); err != nil {
+			return err
+		}
+		if b[1] != 0 {
+			return errors.New("rtmp: socks5 proxy authentication failed")
+		}
+	case b[1] == 0xff && user != nil:
+		return errors.New("rtmp: socks5 proxy does not support username and password authentication")
+	case b[1] == 0xff:
+		return errors.New("rtmp: socks5 proxy requires authentication")
+	case b[1] != 0:
+		return errSocks
+	}
+
+	b = []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return errSocks
+		}
+		b = append(append(b, 3, byte(len(host))), host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		b = append(append(b, 1), ip4...)
+	} else {
+		b = append(append(b, 4), ip...)
+	}
+	b = append(b, byte(p>>8), byte(p))
+	if _, err = c.Write(b); err != nil {
+		return err
+	}
+	if _, err = io.ReadFull(c, b[:4]); err != nil {
+		return err
+	}
+	if b[0] != 5 {
+		return errSocks
+	}
+	if b[1] != 0 {
+		return fmt.Errorf("rtmp: socks5 proxy connect failed with code %d", b[1])
+	}
+	// Skip the bound address
+	var n int
+	switch b[3] {
+	case 1:
+		n = 4
+	case 4:
+		n = 16
+	case 3:
+		if _, err = io.ReadFull(c, b[:1]); err != nil {
+			return err
+		}
+		n = int(b[0])
+	default:
+		return errSocks
+	}
+	_, err = io.ReadFull(c, make([]byte, n+2))
+	return err
+}
+
+// httpConnect establishes the tunnel with HTTP CONNECT request using basic authentication if the user is set.
+func httpConnect(c net.Conn, user *url.Userinfo, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if user != nil {
+		pass, _ := user.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+pass)))
+	}
+	if err := req.Write(c); err != nil {
+		return c, err
+	}
+	br := bufio.NewReader(c)
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		return c, err
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return c, fmt.Errorf("rtmp: proxy connect: %v", res.Status)
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{c, br}, nil
+	}
+	return c, nil
+}
+
+// bufferedConn reads data buffered while reading the response of the proxy.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
@@ -0,0 +1,181 @@
+package rtmp
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenTestProxy serves the proxy protocol on the local address and returns the proxy URL.
+func listenTestProxy(t *testing.T, scheme string, serve func(c net.Conn) (string, bool)) *url.URL {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen:", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				addr, ok := serve(c)
+				if !ok {
+					return
+				}
+				target, err := net.Dial("tcp", addr)
+				if err != nil {
+					return
+				}
+				defer target.Close()
+				go io.Copy(target, c)
+				io.Copy(c, target)
+			}()
+		}
+	}()
+	return &url.URL{Scheme: scheme, Host: l.Addr().String(), User: url.UserPassword("user", "secret")}
+}
+
+func serveSocks(c net.Conn) (string, bool) {
+	b := make([]byte, 262)
+	if _, err := io.ReadFull(c, b[:2]); err != nil {
+		return "", false
+	}
+	io.ReadFull(c, b[:b[1]])
+	c.Write([]byte{5, 2})
+	io.ReadFull(c, b[:2])
+	user := make([]byte, b[1])
+	io.ReadFull(c, user)
+	io.ReadFull(c, b[:1])
+	pass := make([]byte, b[0])
+	io.ReadFull(c, pass)
+	if string(user) != "user" || string(pass) != "secret" {
+		c.Write([]byte{1, 1})
+		return "", false
+	}
+	c.Write([]byte{1, 0})
+	io.ReadFull(c, b[:4])
+	var host string
+	switch b[3] {
+	case 1:
+		io.ReadFull(c, b[:4])
+		host = net.IP(b[:4]).String()
+	case 4:
+		io.ReadFull(c, b[:16])
+		host = net.IP(b[:16]).String()
+	case 3:
+		io.ReadFull(c, b[:1])
+		n := int(b[0])
+		io.ReadFull(c, b[:n])
+		host = string(b[:n])
+	default:
+		return "", false
+	}
+	io.ReadFull(c, b[:2])
+	port := int(b[0])<<8 | int(b[1])
+	c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	return net.JoinHostPort(host, strconv.Itoa(port)), true
+}
+
+func serveConnect(c net.Conn) (string, bool) {
+	req, err := http.ReadRequest(bufio.NewReader(c))
+	if err != nil || req.Method != "CONNECT" {
+		return "", false
+	}
+	if req.Header.Get("Proxy-Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte("user:secret")) {
+		io.WriteString(c, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+		return "", false
+	}
+	io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+	return req.Host, true
+}
+
+func TestDialerProxy(t *testing.T) {
+	uri := listenTestServer(t, &Server{})
+	for _, proxy := range []*url.URL{
+		listenTestProxy(t, "socks5", serveSocks),
+		listenTestProxy(t, "http", serveConnect),
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		c, err := (&Dialer{Proxy: ProxyURL(proxy)}).DialContext(ctx, uri)
+		cancel()
+		if err != nil {
+			t.Fatalf("dial %v: %v", proxy.Scheme, err)
+		}
+		c.Close()
+
+		proxy.User = url.UserPassword("user", "wrong")
+		if _, err = (&Dialer{Proxy: ProxyURL(proxy)}).Dial(uri); err == nil {
+			t.Fatalf("dial %v: wrong password accepted", proxy.Scheme)
+		}
+	}
+}
+
+func TestDialerSocks(t *testing.T) {
+	u, _ := url.Parse(listenTestServer(t, &Server{}))
+	uri := "rtmp://localhost:" + u.Port() + "/live"
+	hosts := make(chan string, 1)
+	serve := func(c net.Conn) (string, bool) {
+		addr, ok := serveSocks(c)
+		host, _, _ := net.SplitHostPort(addr)
+		hosts <- host
+		return addr, ok
+	}
+	// The host name is resolved locally for socks5 and by the proxy for socks5h
+	for scheme, exp := range map[string]string{"socks5": "127.0.0.1", "socks5h": "localhost"} {
+		c, err := (&Dialer{Proxy: ProxyURL(listenTestProxy(t, scheme, serve)), HandshakeTimeout: 5 * time.Second}).Dial(uri)
+		if err != nil {
+			t.Fatalf("dial %v: %v", scheme, err)
+		}
+		c.Close()
+		if host := <-hosts; host != exp {
+			t.Fatalf("dial %v: proxy connected to %v", scheme, host)
+		}
+	}
+
+	proxy := listenTestProxy(t, "socks5", func(c net.Conn) (string, bool) {
+		io.ReadFull(c, make([]byte, 4))
+		c.Write([]byte{5, 0xff})
+		return "", false
+	})
+	if _, err := (&Dialer{Proxy: ProxyURL(proxy)}).Dial(uri); err == nil || !strings.Contains(err.Error(), "does not support") {
+		t.Fatal("dial:", err)
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	t.Setenv("ALL_PROXY", "socks5://proxy:1080")
+	t.Setenv("HTTPS_PROXY", "proxy:3128")
+	t.Setenv("NO_PROXY", "localhost,.internal")
+	for _, it := range []struct {
+		uri, proxy string
+	}{
+		{"rtmp://example.org/live", "socks5://proxy:1080"},
+		{"rtmps://example.org/live", "http://proxy:3128"},
+		{"rtmp://localhost/live", ""},
+		{"rtmp://media.internal/live", ""},
+	} {
+		u, _ := url.Parse(it.uri)
+		p, err := ProxyFromEnvironment(u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if p != nil {
+			got = p.String()
+		}
+		if got != it.proxy {
+			t.Fatalf("proxy of %v: %q", it.uri, got)
+		}
+	}
+}
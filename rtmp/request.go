@@ -1,11 +1,11 @@
 package rtmp
 
 import (
+	"context"
 	"fmt"
-	"github.com/pixelbender/go-rtmp/amf"
-	"log"
 	"sync"
-	"time"
+
+	"github.com/pixelbender/go-rtmp/amf"
 )
 
 type Response struct {
@@ -13,85 +13,117 @@ type Response struct {
 	name string
 }
 
+// error returns *StatusError decoded from the info object of _error response.
 func (req *Response) error() error {
-	if req.name == "_error" {
-		return &ErrorResponse{}
+	if req.name != "_error" {
+		return nil
+	}
+	req.Skip()
+	var v interface{}
+	if req.Decode(&v) != nil {
+		return &StatusError{Level: LevelError}
 	}
-	return nil
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return &StatusError{Level: LevelError, Description: fmt.Sprint(v)}
+	}
+	st := parseStatus(m)
+	return &StatusError{Level: st.Level, Code: st.Code, Description: st.Description}
 }
 
-type ErrorResponse struct {
-	amf.Decoder
+// Call represents the command invoked by the peer.
+type Call struct {
+	Conn *Conn
+	// Stream is the ID of the stream receiving the command, zero for the connection.
+	Stream uint32
+	Name   string
+	// ID is the transaction ID, zero if the peer does not expect the result.
+	ID     int64
+	Object interface{}
+	Args   []interface{}
 }
 
-func (err *ErrorResponse) Error() string {
-	params := make([]interface{}, 0, 10)
-	for {
-		var v interface{}
-		if err.Decode(&v) != nil {
-			break
-		}
-		params = append(params, v)
-	}
-	return fmt.Sprintf("rtmp: error response %+v", params)
-}
+// HandlerFunc handles the command invoked by the peer and returns the result or error sent to the peer.
+type HandlerFunc func(call *Call) (result interface{}, err error)
+
+// maxTransactionID keeps transaction IDs exactly representable by AMF numbers.
+const maxTransactionID = 1<<53 - 1
 
 type requestMux struct {
 	seq int64
 	req map[int64]chan *Response
+	err error
 	mu  sync.Mutex
 }
 
-func (r *requestMux) handleChunk(ch *chunk) error {
-	d := make([]byte, len(ch.Data))
-	copy(d, ch.Data)
-
-	// TODO: peeker
-
-	dec := amf.NewDecoderBytes(0, d)
-	name, err := dec.DecodeString()
-	if err != nil {
-		return err
+// handleResponse delivers _result or _error command to the pending request.
+func (r *requestMux) handleResponse(id int64, name string, dec amf.Decoder) bool {
+	if name != "_result" && name != "_error" {
+		return false
 	}
-	id, err := dec.DecodeInt()
-	if err != nil {
-		return err
-	}
-
 	tx, res := r.getRequest(id), &Response{dec, name}
-	if tx != nil {
-		select {
-		case tx <- res:
-		default:
-		}
-	} else {
-		log.Printf("unhandled: %v", id)
-		for {
-			var p interface{}
-			err := res.Decode(&p)
-			if err != nil {
-				break
-			}
-			log.Printf("%+v", p)
-		}
+	if tx == nil {
+		return true
 	}
-
-	return nil
+	select {
+	case tx <- res:
+	default:
+	}
+	return true
 }
 
-func (r *requestMux) newRequest() (id int64, tx chan *Response) {
-	tx = make(chan *Response, 1)
+func (r *requestMux) newRequest() (id int64, tx chan *Response, err error) {
 	r.mu.Lock()
-	r.seq++
-	id = r.seq
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return 0, nil, r.err
+	}
+	tx = make(chan *Response, 1)
+	id = r.next()
 	if r.req == nil {
 		r.req = make(map[int64]chan *Response)
 	}
 	r.req[id] = tx
-	r.mu.Unlock()
 	return
 }
 
+// nextID returns the transaction ID for the command which result is ignored.
+func (r *requestMux) nextID() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.next()
+}
+
+// next returns the transaction ID not used by pending requests.
+// Zero is reserved for commands without response.
+func (r *requestMux) next() int64 {
+	for {
+		if r.seq++; r.seq > maxTransactionID {
+			r.seq = 1
+		}
+		if _, ok := r.req[r.seq]; !ok {
+			return r.seq
+		}
+	}
+}
+
+// close fails pending and subsequent requests with the error of the connection.
+func (r *requestMux) close(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.err = err
+	for _, tx := range r.req {
+		close(tx)
+	}
+	r.req = nil
+}
+
+func (r *requestMux) closeError() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
 func (r *requestMux) getRequest(id int64) (tx chan *Response) {
 	r.mu.Lock()
 	tx = r.req[id]
@@ -107,35 +139,19 @@ func (r *requestMux) deleteRequest(id int64) {
 }
 
 func (r *requestMux) write(c *Conn, str uint32, name string, args ...interface{}) error {
-	enc := amf.NewEncoder(0)
-	enc.EncodeString(name)
-	enc.EncodeInt(0)
-	enc.EncodeNull()
-	for _, it := range args {
-		if err := enc.Encode(it); err != nil {
-			return err
-		}
-	}
-	c.w.WriteFull(0x3, 0, msgAmf0Command, str, enc.Bytes())
-	return nil
+	return c.writeCommand(str, name, 0, append([]interface{}{nil}, args...)...)
 }
 
-func (r *requestMux) request(c *Conn, str uint32, name string, args ...interface{}) (res *Response, err error) {
-	id, tx := r.newRequest()
+func (r *requestMux) request(ctx context.Context, c *Conn, str uint32, name string, args ...interface{}) (res *Response, err error) {
+	id, tx, err := r.newRequest()
+	if err != nil {
+		return
+	}
 	defer r.deleteRequest(id)
 
-	log.Printf("%s(%v) %+v", name, str, args)
-
-	enc := amf.NewEncoder(0)
-	enc.EncodeString(name)
-	enc.EncodeInt(id)
-	for _, it := range args {
-		if err = enc.Encode(it); err != nil {
-			return
-		}
+	if err = c.writeCommand(str, name, id, args...); err != nil {
+		return
 	}
-	c.w.WriteFull(0x3, 0, msgAmf0Command, str, enc.Bytes())
-
 	if err = c.w.Flush(); err != nil {
 		return
 	}
@@ -143,12 +159,14 @@ func (r *requestMux) request(c *Conn, str uint32, name string, args ...interface
 	select {
 	case res, _ = <-tx:
 		if res == nil {
-			err = ErrTimeout
+			err = r.closeError()
 		} else {
 			err = res.error()
 		}
-	case <-time.After(c.RequestTimeout):
-		err = ErrTimeout
+	case <-ctx.Done():
+		if err = ctx.Err(); err == context.DeadlineExceeded {
+			err = ErrTimeout
+		}
 	}
 
 	return
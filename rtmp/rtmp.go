@@ -1,38 +1,19 @@
 package rtmp
 
 import (
-	"net"
-	"net/url"
-	"strings"
+	"context"
+	"crypto/tls"
 )
 
-// TODO: dial with dialer
-
+// Dial connects to the RTMP server, performs the handshake and starts serving the connection in background.
+// Use Connect to connect to the application of the URL.
 func Dial(uri string) (*Conn, error) {
-	u, err := url.Parse(uri)
-	if err != nil {
-		return nil, err
-	}
-	host, port, err := net.SplitHostPort(u.Host)
-	if err != nil {
-		host = u.Host
-	}
-	var c net.Conn
-	switch strings.ToLower(u.Scheme) {
-	case "rtmps":
-	//...
-	case "rtmp":
-		if port == "" {
-			port = "1935"
-		}
-		if c, err = net.Dial("tcp", net.JoinHostPort(host, port)); err != nil {
-			return nil, err
-		}
-	}
-	conn := NewConn(c)
-	err = conn.Handshake()
-	if err != nil {
-		return nil, err
-	}
-	return conn, nil
+	return DialTLS(uri, nil)
+}
+
+// DialTLS is like Dial but uses the TLS configuration for rtmps:// URLs.
+// ServerName of the configuration is set to the host of the URL if empty.
+func DialTLS(uri string, config *tls.Config) (*Conn, error) {
+	d := &Dialer{TLSConfig: config}
+	return d.dial(context.Background(), uri)
 }
@@ -5,11 +5,38 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"strings"
+	"sync"
 	"time"
+
+	"github.com/pixelbender/go-rtmp/amf"
 )
 
 // Server represents a RTMP server.
+// Media published to a stream name is routed to players of the same application and stream name.
 type Server struct {
+	// Handler decides on requests of connections, NopHandler is used if nil.
+	Handler Handler
+
+	// AllowEncryption enables RTMPE handshake of the types 6, 8 and 9, encrypting the connection with RC4.
+	AllowEncryption bool
+
+	// TLSConfig is used by ListenAndServeTLS and ServeTLS.
+	TLSConfig *tls.Config
+
+	// ChunkSize is the size of chunks sent to clients, 4096 bytes if zero.
+	ChunkSize int
+
+	// AckWindowSize is the number of bytes clients send between acknowledgements
+	// of the server and the peer bandwidth of clients, 2500000 bytes if zero.
+	AckWindowSize uint32
+
+	// ErrorLog is an optional logger of connection errors, such as failed handshakes.
+	// Errors are not logged if nil.
+	ErrorLog *log.Logger
+
+	mu      sync.Mutex
+	streams map[string]*broadcast
 }
 
 func (srv *Server) ListenAndServe(network, addr string) error {
@@ -21,21 +48,37 @@ func (srv *Server) ListenAndServe(network, addr string) error {
 		}
 		return srv.Serve(tcpKeepAliveListener{l.(*net.TCPListener)})
 	}
-	return fmt.Errorf("stun: listen unsupported network %v", network)
+	return fmt.Errorf("rtmp: listen unsupported network %v", network)
 }
 
+// ListenAndServeTLS listens on the TCP network address and serves RTMPS connections.
+// The certificate and key files are loaded if not empty, otherwise TLSConfig must contain the certificates.
 func (srv *Server) ListenAndServeTLS(network, addr, certFile, keyFile string) error {
-	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
-	if err != nil {
-		return err
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		l, err := net.Listen(network, addr)
+		if err != nil {
+			return err
+		}
+		return srv.ServeTLS(tcpKeepAliveListener{l.(*net.TCPListener)}, certFile, keyFile)
 	}
-	config := &tls.Config{Certificates: []tls.Certificate{cert}}
-	l, err := net.Listen(network, addr)
-	if err != nil {
-		return err
+	return fmt.Errorf("rtmp: listen unsupported network %v", network)
+}
+
+// ServeTLS accepts connections on the listener and serves RTMPS connections using TLSConfig.
+func (srv *Server) ServeTLS(l net.Listener, certFile, keyFile string) error {
+	config := &tls.Config{}
+	if srv.TLSConfig != nil {
+		config = srv.TLSConfig.Clone()
+	}
+	if certFile != "" || keyFile != "" || len(config.Certificates) == 0 && config.GetCertificate == nil {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		config.Certificates = append(config.Certificates, cert)
 	}
-	l = tls.NewListener(tcpKeepAliveListener{l.(*net.TCPListener)}, config)
-	return srv.Serve(l)
+	return srv.Serve(tls.NewListener(l, config))
 }
 
 // Multiple goroutines may invoke Serve on the same Listener simultaneously.
@@ -53,17 +96,246 @@ func (srv *Server) Serve(l net.Listener) error {
 	}
 }
 
+func (srv *Server) chunkSize() int {
+	if n := srv.ChunkSize; n > 0 && n <= MaxChunkSize {
+		return n
+	}
+	return 4096
+}
+
+func (srv *Server) ackWindowSize() uint32 {
+	if srv.AckWindowSize > 0 {
+		return srv.AckWindowSize
+	}
+	return 2500000
+}
+
+func (srv *Server) logf(format string, args ...interface{}) {
+	if srv.ErrorLog != nil {
+		srv.ErrorLog.Printf(format, args...)
+	}
+}
+
 func (srv *Server) serveConn(conn net.Conn) error {
 	c := NewConn(conn)
+	c.server = srv
 	defer c.Close()
-	//err := clientHandshake(c)
-	var err error
+	if err := serverHandshake(c); err != nil {
+		srv.logf("rtmp: handshake error from %v: %v", conn.RemoteAddr(), err)
+		return err
+	}
+	defer srv.handler().OnClose(c)
+	defer srv.closeStreams(c)
+	return c.Serve()
+}
+
+func (srv *Server) handler() Handler {
+	if srv.Handler == nil {
+		return NopHandler{}
+	}
+	return srv.Handler
+}
+
+func (srv *Server) serveCommand(c *Conn, str uint32, name string, id int64, dec amf.Decoder) (err error) {
+	switch name {
+	case "connect":
+		err = srv.connect(c, id, dec)
+	case "createStream":
+		s := c.newStream()
+		err = c.writeCommand(0, "_result", id, nil, s.id)
+	case "deleteStream":
+		dec.Skip()
+		var sid int64
+		if sid, err = dec.ReadInt(); err != nil {
+			return
+		}
+		if s := c.deleteStream(sid); s != nil {
+			srv.closeStream(s)
+		}
+	case "closeStream":
+		if s := c.getStream(int64(str)); s != nil {
+			srv.closeStream(s)
+		}
+	case "publish":
+		if s := c.getStream(int64(str)); s != nil {
+			dec.Skip()
+			req := &PublishRequest{App: c.app, Type: "live"}
+			if req.Name, err = dec.ReadString(); err != nil {
+				return
+			}
+			if t, _ := dec.ReadString(); t != "" {
+				req.Type = t
+			}
+			srv.publish(s, req)
+		}
+	case "play":
+		if s := c.getStream(int64(str)); s != nil {
+			dec.Skip()
+			req := &PlayRequest{App: c.app, Start: -2, Duration: -1, Reset: true}
+			if req.Name, err = dec.ReadString(); err != nil {
+				return
+			}
+			if v, err := dec.ReadFloat(); err == nil {
+				req.Start = v
+				if v, err = dec.ReadFloat(); err == nil {
+					req.Duration = v
+					req.Reset, _ = dec.ReadBool()
+				}
+			}
+			srv.play(s, req)
+		}
+	default:
+		err = srv.call(c, name, id, dec)
+	}
 	if err != nil {
-		log.Printf("handshake: %v", err)
+		return
+	}
+	return c.w.Flush()
+}
+
+func (srv *Server) connect(c *Conn, id int64, dec amf.Decoder) error {
+	info := &ClientInfo{}
+	if err := dec.Decode(info); err != nil {
+		return err
+	}
+	if err := srv.handler().OnConnect(c, info); err != nil {
+		c.writeCommand(0, "_error", id, nil, errorStatus(err, NetConnectionConnectRejected))
+		c.w.Flush()
+		return err
+	}
+	c.app = info.App
+	window := srv.ackWindowSize()
+	c.setAckSize(window)
+	b := make([]byte, 5)
+	be.PutUint32(b, window)
+	b[4] = limitDynamic
+	c.w.WriteMessage(chunkControl, 0, msgSetBandwidth, 0, b)
+	c.w.SetChunkSize(srv.chunkSize())
+	c.writeControl(ControlStreamBegin, 0)
+	return c.writeCommand(0, "_result", id, map[string]interface{}{
+		"fmsVer":       "FMS/3,0,1,123",
+		"capabilities": 31,
+	}, map[string]interface{}{
+		"level":          "status",
+		"code":           NetConnectionConnectSuccess,
+		"description":    "Connection succeeded.",
+		"objectEncoding": 0,
+	})
+}
+
+func (srv *Server) publish(s *Stream, req *PublishRequest) {
+	srv.closeStream(s)
+	if err := srv.handler().OnPublish(s.conn, req); err != nil {
+		s.sendStatus(errorStatus(err, NetStreamPublishDenied))
+		return
+	}
+	bc := srv.getBroadcast(req.App, req.Name)
+	s.name = req.Name
+	if !bc.publish(s) {
+		s.sendStatus(newError(NetStreamPublishBadName, req.Name+" is already published."))
+		srv.releaseBroadcast(bc)
+		return
+	}
+	s.bc, s.publishing = bc, true
+	s.conn.writeControl(ControlStreamBegin, s.id)
+	s.sendStatus(NewStatus(NetStreamPublishStart, req.Name+" is now published."))
+}
+
+func (srv *Server) play(s *Stream, req *PlayRequest) {
+	srv.closeStream(s)
+	if err := srv.handler().OnPlay(s.conn, req); err != nil {
+		s.sendStatus(errorStatus(err, NetStreamPlayFailed))
+		return
+	}
+	s.name = req.Name
+	s.conn.writeControl(ControlStreamBegin, s.id)
+	if req.Reset {
+		s.sendStatus(NewStatus(NetStreamPlayReset, "Playing and resetting "+req.Name+"."))
+	}
+	s.sendStatus(NewStatus(NetStreamPlayStart, "Started playing "+req.Name+"."))
+	enc := amf.NewEncoder(0)
+	enc.WriteString("|RtmpSampleAccess")
+	enc.WriteBool(true)
+	enc.WriteBool(true)
+	s.conn.w.WriteMessage(chunkData, 0, msgAmf0Meta, s.id, enc.Bytes())
+	s.bc = srv.getBroadcast(req.App, req.Name)
+	s.bc.play(s)
+}
+
+// call passes the command to the handler and responds if the transaction ID is set.
+func (srv *Server) call(c *Conn, name string, id int64, dec amf.Decoder) error {
+	var args []interface{}
+	for {
+		var v interface{}
+		if dec.Decode(&v) != nil {
+			break
+		}
+		args = append(args, v)
+	}
+	res, err := srv.handler().OnCall(c, name, args)
+	if id == 0 {
+		return nil
+	}
+	if err != nil {
+		return c.writeCommand(0, "_error", id, nil, errorStatus(err, NetConnectionCallFailed))
+	}
+	return c.writeCommand(0, "_result", id, nil, res)
+}
+
+// closeStream stops publishing or playing the stream.
+func (srv *Server) closeStream(s *Stream) {
+	bc := s.bc
+	if bc == nil {
+		return
+	}
+	if s.publishing {
+		bc.unpublish(s)
+		s.sendStatus(NewStatus(NetStreamUnpublishSuccess, s.name+" is now unpublished."))
 	} else {
-		log.Printf("handshake done")
+		bc.stop(s)
+	}
+	s.bc, s.publishing = nil, false
+	srv.releaseBroadcast(bc)
+}
+
+func (srv *Server) closeStreams(c *Conn) {
+	c.strmu.Lock()
+	list := make([]*Stream, 0, len(c.str))
+	for id, s := range c.str {
+		list = append(list, s)
+		delete(c.str, id)
+	}
+	c.strmu.Unlock()
+	for _, s := range list {
+		srv.closeStream(s)
+	}
+}
+
+func (srv *Server) getBroadcast(app, name string) *broadcast {
+	if i := strings.IndexByte(name, '?'); i >= 0 {
+		name = name[:i]
+	}
+	key := app + "/" + name
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	bc := srv.streams[key]
+	if bc == nil {
+		if srv.streams == nil {
+			srv.streams = make(map[string]*broadcast)
+		}
+		bc = &broadcast{key: key}
+		srv.streams[key] = bc
+	}
+	bc.refs++
+	return bc
+}
+
+func (srv *Server) releaseBroadcast(bc *broadcast) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if bc.refs--; bc.refs == 0 {
+		delete(srv.streams, bc.key)
 	}
-	return nil
 }
 
 type tcpKeepAliveListener struct {
@@ -0,0 +1,331 @@
+package rtmp
+
+import (
+	"bytes"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pixelbender/go-rtmp/amf"
+)
+
+func listenTestServer(t *testing.T, srv *Server) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("listen:", err)
+	}
+	go srv.Serve(l)
+	return "rtmp://" + l.Addr().String() + "/live"
+}
+
+// readCommand reads chunks until the command message and returns its name, transaction ID and arguments.
+func readCommand(t *testing.T, c *Conn) (string, int64, amf.Decoder) {
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		ch, err := c.r.ReadChunk()
+		if err != nil {
+			t.Fatal("read chunk:", err)
+		}
+		switch ch.Type {
+		case msgSetChunkSize:
+			c.r.size = int(be.Uint32(ch.Data))
+		case msgAmf0Command:
+			d := make([]byte, len(ch.Data))
+			copy(d, ch.Data)
+			dec := amf.NewDecoder(0, d)
+			name, _ := dec.ReadString()
+			id, _ := dec.ReadInt()
+			return name, id, dec
+		}
+	}
+}
+
+// readStatus reads commands until onStatus and returns its code.
+func readStatus(t *testing.T, c *Conn) string {
+	for {
+		name, _, dec := readCommand(t, c)
+		if name != "onStatus" {
+			continue
+		}
+		dec.Skip()
+		st := &Status{}
+		if err := dec.Decode(st); err != nil {
+			t.Fatal("decode status:", err)
+		}
+		return st.Code
+	}
+}
+
+func readMedia(t *testing.T, c *Conn, typ uint8) *chunk {
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		ch, err := c.r.ReadChunk()
+		if err != nil {
+			t.Fatal("read chunk:", err)
+		}
+		switch ch.Type {
+		case msgSetChunkSize:
+			c.r.size = int(be.Uint32(ch.Data))
+		case typ:
+			return ch
+		}
+	}
+}
+
+// dialTestConn performs the handshake without serving the connection.
+func dialTestConn(t *testing.T, uri string) *Conn {
+	u, _ := url.Parse(uri)
+	conn, err := net.Dial("tcp", u.Host)
+	if err != nil {
+		t.Fatal("dial:", err)
+	}
+	c := NewConn(conn)
+	if err = c.Handshake(); err != nil {
+		t.Fatal("handshake:", err)
+	}
+	return c
+}
+
+func dialTestStream(t *testing.T, uri string) (*Conn, *Stream) {
+	c := dialTestConn(t, uri)
+	c.writeCommand(0, "connect", 1, &ClientInfo{App: "live", TcURL: uri})
+	c.w.Flush()
+	if name, id, _ := readCommand(t, c); name != "_result" || id != 1 {
+		t.Fatalf("connect: %s(%d)", name, id)
+	}
+	c.writeCommand(0, "createStream", 2, nil)
+	c.w.Flush()
+	name, id, dec := readCommand(t, c)
+	if name != "_result" || id != 2 {
+		t.Fatalf("createStream: %s(%d)", name, id)
+	}
+	dec.Skip()
+	sid, err := dec.ReadInt()
+	if err != nil {
+		t.Fatal("createStream:", err)
+	}
+	return c, &Stream{conn: c, id: uint32(sid)}
+}
+
+func TestServer(t *testing.T) {
+	uri := listenTestServer(t, &Server{})
+
+	pc, pub := dialTestStream(t, uri)
+	defer pc.Close()
+	pub.Send("publish", "test", "live")
+	pub.Flush()
+	if code := readStatus(t, pc); code != "NetStream.Publish.Start" {
+		t.Fatal("publish:", code)
+	}
+	header := []byte{0x17, 0, 0, 0, 0, 1, 0x64, 0, 0x1f, 0xff}
+	pc.w.WriteMessage(chunkVideo, 0, msgVideo, pub.id, header)
+	pc.w.Flush()
+
+	c, s := dialTestStream(t, uri)
+	defer c.Close()
+	s.Play("test")
+	if code := readStatus(t, c); code != "NetStream.Play.Reset" {
+		t.Fatal("play:", code)
+	}
+	if code := readStatus(t, c); code != "NetStream.Play.Start" {
+		t.Fatal("play:", code)
+	}
+	if ch := readMedia(t, c, msgVideo); !bytes.Equal(ch.Data, header) {
+		t.Fatalf("sequence header: %x", ch.Data)
+	}
+
+	frame := []byte{0x17, 1, 0, 0, 0, 0, 0, 0, 2, 0x65, 0x88}
+	pc.w.WriteMessage(chunkVideo, 40, msgVideo, pub.id, frame)
+	pc.w.Flush()
+	if ch := readMedia(t, c, msgVideo); !bytes.Equal(ch.Data, frame) || ch.Time != 40 || ch.Stream != s.id {
+		t.Fatalf("frame: %+v", ch)
+	}
+
+	c2, s2 := dialTestStream(t, uri)
+	defer c2.Close()
+	s2.Send("publish", "test", "live")
+	s2.Flush()
+	if code := readStatus(t, c2); code != "NetStream.Publish.BadName" {
+		t.Fatal("publish:", code)
+	}
+
+	pub.Close()
+	if code := readStatus(t, c); code != "NetStream.Play.UnpublishNotify" {
+		t.Fatal("unpublish:", code)
+	}
+}
+
+func TestServerSlowPlayer(t *testing.T) {
+	uri := listenTestServer(t, &Server{})
+
+	pc, pub := dialTestStream(t, uri)
+	defer pc.Close()
+	pub.Send("publish", "test", "live")
+	pub.Flush()
+	if code := readStatus(t, pc); code != "NetStream.Publish.Start" {
+		t.Fatal("publish:", code)
+	}
+
+	// The slow player limits the bandwidth and never acknowledges
+	sc, slow := dialTestStream(t, uri)
+	defer sc.Close()
+	sc.w.WriteMessage(chunkControl, 0, msgSetBandwidth, 0, []byte{0, 1, 0x86, 0xa0, limitHard})
+	slow.Play("test")
+
+	c, s := dialTestStream(t, uri)
+	defer c.Close()
+	s.Play("test")
+	if code := readStatus(t, c); code != "NetStream.Play.Reset" {
+		t.Fatal("play:", code)
+	}
+
+	// The publisher and the other player are not stalled by the slow player
+	for i := 0; i < 1000; i += 50 {
+		for j := i; j < i+50; j++ {
+			pc.w.WriteMessage(chunkVideo, int64(j*40), msgVideo, pub.id, make([]byte, 1000))
+		}
+		pc.w.Flush()
+		for j := i; j < i+50; j++ {
+			if ch := readMedia(t, c, msgVideo); ch.Time != int64(j*40) {
+				t.Fatalf("frame %d: %+v", j, ch)
+			}
+		}
+	}
+
+	// The slow player stays connected, it is notified and receives the next key frame once it acknowledges
+	events := make(chan string, 16)
+	go func() {
+		defer close(events)
+		for {
+			ch, err := sc.r.ReadChunk()
+			if err != nil {
+				return
+			}
+			b := make([]byte, 4)
+			be.PutUint32(b, sc.r.in.n)
+			sc.w.WriteMessage(chunkControl, 0, msgAck, 0, b)
+			if sc.w.Flush() != nil {
+				return
+			}
+			switch {
+			case ch.Type == msgSetChunkSize:
+				sc.r.size = int(be.Uint32(ch.Data))
+			case ch.Type == msgAmf0Command:
+				dec := amf.NewDecoder(0, ch.Data)
+				dec.Skip()
+				dec.Skip()
+				dec.Skip()
+				st := &Status{}
+				if dec.Decode(st) == nil {
+					events <- st.Code
+				}
+			case ch.Type == msgVideo && isKeyFrame(ch.Type, ch.Data):
+				events <- "key"
+				return
+			}
+		}
+	}()
+	sc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	key := []byte{0x17, 0x01, 0, 0, 0}
+	var notified bool
+	for i := 1000; ; i++ {
+		pc.w.WriteMessage(chunkVideo, int64(i*40), msgVideo, pub.id, key)
+		pc.w.Flush()
+		select {
+		case code, ok := <-events:
+			switch {
+			case !ok:
+				t.Fatal("slow player is disconnected")
+			case code == NetStreamPlayInsufficientBW:
+				notified = true
+			case code == "key":
+				if !notified {
+					t.Fatal("slow player is not notified")
+				}
+				return
+			}
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+type testHandler struct {
+	NopHandler
+	closed chan *Conn
+}
+
+func (h *testHandler) OnPublish(c *Conn, req *PublishRequest) error {
+	if req.Name == "denied" {
+		return &StatusError{Code: "NetStream.Publish.Unauthorized", Description: "Invalid token."}
+	}
+	req.Name = "renamed"
+	return nil
+}
+
+func (h *testHandler) OnPlay(c *Conn, req *PlayRequest) error {
+	req.Name = "renamed"
+	return nil
+}
+
+func (h *testHandler) OnCall(c *Conn, name string, args []interface{}) (interface{}, error) {
+	if name != "echo" || len(args) != 2 {
+		return nil, &StatusError{Code: "NetConnection.Call.Failed"}
+	}
+	return args[1], nil
+}
+
+func (h *testHandler) OnClose(c *Conn) {
+	h.closed <- c
+}
+
+func TestServerHandler(t *testing.T) {
+	h := &testHandler{closed: make(chan *Conn, 2)}
+	uri := listenTestServer(t, &Server{Handler: h})
+
+	pc, pub := dialTestStream(t, uri)
+	pub.Send("publish", "denied", "live")
+	pub.Flush()
+	if code := readStatus(t, pc); code != "NetStream.Publish.Unauthorized" {
+		t.Fatal("publish:", code)
+	}
+	pub.Send("publish", "original", "live")
+	pub.Flush()
+	if code := readStatus(t, pc); code != "NetStream.Publish.Start" {
+		t.Fatal("publish:", code)
+	}
+
+	pc.writeCommand(0, "echo", 3, nil, "hello")
+	pc.w.Flush()
+	name, id, dec := readCommand(t, pc)
+	dec.Skip()
+	if v, _ := dec.ReadString(); name != "_result" || id != 3 || v != "hello" {
+		t.Fatalf("echo: %s(%d) %q", name, id, v)
+	}
+	pc.writeCommand(0, "unknown", 4, nil)
+	pc.w.Flush()
+	if name, id, _ := readCommand(t, pc); name != "_error" || id != 4 {
+		t.Fatalf("unknown: %s(%d)", name, id)
+	}
+
+	c, s := dialTestStream(t, uri)
+	s.Play("other")
+	readStatus(t, c)
+	readStatus(t, c)
+	header := []byte{0x17, 0, 0, 0, 0, 1, 0x64, 0, 0x1f, 0xff}
+	pc.w.WriteMessage(chunkVideo, 0, msgVideo, pub.id, header)
+	pc.w.Flush()
+	if ch := readMedia(t, c, msgVideo); !bytes.Equal(ch.Data, header) {
+		t.Fatalf("rewrite: %x", ch.Data)
+	}
+
+	pc.Close()
+	c.Close()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-h.closed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("close: timeout")
+		}
+	}
+}
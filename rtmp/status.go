@@ -0,0 +1,152 @@
+package rtmp
+
+import (
+	"strconv"
+
+	"github.com/pixelbender/go-rtmp/amf"
+)
+
+// Levels of the status.
+const (
+	LevelStatus  = "status"
+	LevelWarning = "warning"
+	LevelError   = "error"
+)
+
+// Status codes of NetConnection.
+const (
+	NetConnectionCallBadVersion       = "NetConnection.Call.BadVersion"
+	NetConnectionCallFailed           = "NetConnection.Call.Failed"
+	NetConnectionCallProhibited       = "NetConnection.Call.Prohibited"
+	NetConnectionConnectAppShutdown   = "NetConnection.Connect.AppShutdown"
+	NetConnectionConnectClosed        = "NetConnection.Connect.Closed"
+	NetConnectionConnectFailed        = "NetConnection.Connect.Failed"
+	NetConnectionConnectIdleTimeout   = "NetConnection.Connect.IdleTimeout"
+	NetConnectionConnectInvalidApp    = "NetConnection.Connect.InvalidApp"
+	NetConnectionConnectNetworkChange = "NetConnection.Connect.NetworkChange"
+	NetConnectionConnectRejected      = "NetConnection.Connect.Rejected"
+	NetConnectionConnectSuccess       = "NetConnection.Connect.Success"
+)
+
+// Status codes of NetStream.
+const (
+	NetStreamBufferEmpty               = "NetStream.Buffer.Empty"
+	NetStreamBufferFlush               = "NetStream.Buffer.Flush"
+	NetStreamBufferFull                = "NetStream.Buffer.Full"
+	NetStreamConnectClosed             = "NetStream.Connect.Closed"
+	NetStreamConnectFailed             = "NetStream.Connect.Failed"
+	NetStreamConnectRejected           = "NetStream.Connect.Rejected"
+	NetStreamConnectSuccess            = "NetStream.Connect.Success"
+	NetStreamFailed                    = "NetStream.Failed"
+	NetStreamPauseNotify               = "NetStream.Pause.Notify"
+	NetStreamPlayComplete              = "NetStream.Play.Complete"
+	NetStreamPlayFailed                = "NetStream.Play.Failed"
+	NetStreamPlayFileStructureInvalid  = "NetStream.Play.FileStructureInvalid"
+	NetStreamPlayInsufficientBW        = "NetStream.Play.InsufficientBW"
+	NetStreamPlayNoSupportedTrackFound = "NetStream.Play.NoSupportedTrackFound"
+	NetStreamPlayPublishNotify         = "NetStream.Play.PublishNotify"
+	NetStreamPlayReset                 = "NetStream.Play.Reset"
+	NetStreamPlayStart                 = "NetStream.Play.Start"
+	NetStreamPlayStop                  = "NetStream.Play.Stop"
+	NetStreamPlayStreamNotFound        = "NetStream.Play.StreamNotFound"
+	NetStreamPlayTransition            = "NetStream.Play.Transition"
+	NetStreamPlayUnpublishNotify       = "NetStream.Play.UnpublishNotify"
+	NetStreamPublishBadName            = "NetStream.Publish.BadName"
+	NetStreamPublishDenied             = "NetStream.Publish.Denied"
+	NetStreamPublishIdle               = "NetStream.Publish.Idle"
+	NetStreamPublishStart              = "NetStream.Publish.Start"
+	NetStreamRecordAlreadyExists       = "NetStream.Record.AlreadyExists"
+	NetStreamRecordDiskQuotaExceeded   = "NetStream.Record.DiskQuotaExceeded"
+	NetStreamRecordFailed              = "NetStream.Record.Failed"
+	NetStreamRecordNoAccess            = "NetStream.Record.NoAccess"
+	NetStreamRecordStart               = "NetStream.Record.Start"
+	NetStreamRecordStop                = "NetStream.Record.Stop"
+	NetStreamSeekFailed                = "NetStream.Seek.Failed"
+	NetStreamSeekInvalidTime           = "NetStream.Seek.InvalidTime"
+	NetStreamSeekNotify                = "NetStream.Seek.Notify"
+	NetStreamStepNotify                = "NetStream.Step.Notify"
+	NetStreamUnpauseNotify             = "NetStream.Unpause.Notify"
+	NetStreamUnpublishSuccess          = "NetStream.Unpublish.Success"
+	NetStreamVideoDimensionChange      = "NetStream.Video.DimensionChange"
+)
+
+// Status represents the info object of onStatus and _error commands.
+type Status struct {
+	Level       string `amf:"level"`
+	Code        string `amf:"code"`
+	Description string `amf:"description,omitempty"`
+	Details     string `amf:"details,omitempty"`
+	ClientID    string `amf:"clientid,omitempty"`
+	// Extra contains other properties of the info object, such as objectEncoding or application data.
+	Extra map[string]interface{}
+}
+
+// NewStatus returns the status of the level "status" with the code and description.
+func NewStatus(code, desc string) *Status {
+	return &Status{Level: LevelStatus, Code: code, Description: desc}
+}
+
+func newError(code, desc string) *Status {
+	return &Status{Level: LevelError, Code: code, Description: desc}
+}
+
+// Err returns *StatusError for the status of the level "error" or nil otherwise.
+func (st *Status) Err() error {
+	if st.Level != LevelError {
+		return nil
+	}
+	return &StatusError{Level: st.Level, Code: st.Code, Description: st.Description}
+}
+
+// object returns the info object with non-empty properties.
+func (st *Status) object() map[string]interface{} {
+	m := make(map[string]interface{}, 5+len(st.Extra))
+	for k, v := range st.Extra {
+		m[k] = v
+	}
+	m["level"], m["code"] = st.Level, st.Code
+	for k, v := range map[string]string{"description": st.Description, "details": st.Details, "clientid": st.ClientID} {
+		if v != "" {
+			m[k] = v
+		}
+	}
+	return m
+}
+
+// decodeStatus decodes the info object of the status.
+func decodeStatus(dec amf.Decoder) (*Status, error) {
+	var m map[string]interface{}
+	if err := dec.Decode(&m); err != nil {
+		return nil, err
+	}
+	return parseStatus(m), nil
+}
+
+// parseStatus returns the status of the info object keeping unknown properties in Extra.
+func parseStatus(m map[string]interface{}) *Status {
+	st := &Status{}
+	for k, v := range m {
+		s, ok := v.(string)
+		if f, isNum := v.(float64); isNum && k == "clientid" {
+			s, ok = strconv.FormatFloat(f, 'f', -1, 64), true
+		}
+		switch {
+		case k == "level" && ok:
+			st.Level = s
+		case k == "code" && ok:
+			st.Code = s
+		case k == "description" && ok:
+			st.Description = s
+		case k == "details" && ok:
+			st.Details = s
+		case k == "clientid" && ok:
+			st.ClientID = s
+		default:
+			if st.Extra == nil {
+				st.Extra = make(map[string]interface{})
+			}
+			st.Extra[k] = v
+		}
+	}
+	return st
+}
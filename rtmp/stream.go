@@ -1,8 +1,42 @@
 package rtmp
 
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/pixelbender/go-rtmp/amf"
+	"github.com/pixelbender/go-rtmp/flv"
+)
+
+// ErrSlowReader is returned by ReadPacket in place of packets dropped because they were not read
+// as fast as they were received, if ReportDrops of the connection is set.
+// The stream keeps playing, the next ReadPacket returns the packet following the dropped ones.
+var ErrSlowReader = errors.New("rtmp: packets are not read fast enough")
+
+// Packet represents the media message of the stream.
+// Type is one of flv.TypeAudio, flv.TypeVideo and flv.TypeData, Data is the FLV tag data.
+type Packet struct {
+	Type uint8
+	Time int64
+	Data []byte
+
+	dropped bool // packets before this one were dropped
+}
+
 type Stream struct {
-	conn *Conn
-	id   uint32
+	conn     *Conn
+	id       uint32
+	events   chan *Status
+	controls chan *UserControl
+	packets  chan *Packet
+	next     *Packet // packet delayed by ErrSlowReader
+	skip     bool    // packets are dropped up to the next video key frame
+	video    bool
+
+	name       string
+	bc         *broadcast
+	publishing bool
 }
 
 func (s *Stream) Send(name string, args ...interface{}) {
@@ -13,6 +47,61 @@ func (s *Stream) Flush() error {
 	return s.conn.w.Flush()
 }
 
+// Events returns the channel of onStatus events received for the stream,
+// such as NetStream.Play.Start, NetStream.Play.StreamNotFound and NetStream.Play.Stop.
+// Events are dropped if the channel is not read.
+func (s *Stream) Events() <-chan *Status {
+	return s.events
+}
+
+// Controls returns the channel of user control events received for the stream,
+// such as ControlStreamBegin, ControlStreamEOF, ControlStreamDry and ControlStreamRecorded.
+// Events are dropped if the channel is not read.
+func (s *Stream) Controls() <-chan *UserControl {
+	return s.controls
+}
+
+// SetBufferLength informs the server of the buffer length used to play the stream.
+func (s *Stream) SetBufferLength(d time.Duration) error {
+	s.conn.writeControl(ControlSetBufferLength, s.id, uint32(d/time.Millisecond))
+	return s.Flush()
+}
+
+// ReadPacket reads the next media packet received by the stream.
+// It returns the connection error when the connection is closed.
+// The connection does not wait for the reader: if the packet buffer is full,
+// packets are dropped up to the next video key frame, see PacketBuffer and ReportDrops of Conn.
+func (s *Stream) ReadPacket() (*Packet, error) {
+	if p := s.next; p != nil {
+		s.next = nil
+		return p, nil
+	}
+	select {
+	case p := <-s.packets:
+		return s.packet(p)
+	default:
+	}
+	select {
+	case p := <-s.packets:
+		return s.packet(p)
+	case <-s.conn.done:
+		select {
+		case p := <-s.packets:
+			return s.packet(p)
+		default:
+			return nil, s.conn.err
+		}
+	}
+}
+
+func (s *Stream) packet(p *Packet) (*Packet, error) {
+	if p.dropped && s.conn.ReportDrops {
+		s.next = p
+		return nil, ErrSlowReader
+	}
+	return p, nil
+}
+
 func (s *Stream) Play(name string) error {
 	s.Send("receiveAudio", true)
 	s.Send("receiveVideo", true)
@@ -20,7 +109,167 @@ func (s *Stream) Play(name string) error {
 	return s.Flush()
 }
 
+// Publish publishes the stream with the name and type: live, record or append.
+// It waits for NetStream.Publish.Start status and returns *StatusError if publishing fails.
+func (s *Stream) Publish(ctx context.Context, name, typ string) error {
+	s.conn.releaseStream(name)
+	return s.publish(ctx, name, typ)
+}
+
+func (s *Stream) publish(ctx context.Context, name, typ string) error {
+	if typ == "" {
+		typ = "live"
+	}
+	s.Send("publish", name, typ)
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	for {
+		select {
+		case st := <-s.events:
+			if err := st.Err(); err != nil {
+				return err
+			}
+			if st.Code == NetStreamPublishStart {
+				return nil
+			}
+		case <-s.conn.done:
+			return s.conn.err
+		case <-ctx.Done():
+			if err := ctx.Err(); err != context.DeadlineExceeded {
+				return err
+			}
+			return ErrTimeout
+		}
+	}
+}
+
+// WriteAudio writes the audio message with the FLV audio tag data.
+func (s *Stream) WriteAudio(ts int64, data []byte) error {
+	return s.writeMedia(msgAudio, ts, data)
+}
+
+// WriteVideo writes the video message with the FLV video tag data.
+func (s *Stream) WriteVideo(ts int64, data []byte) error {
+	return s.writeMedia(msgVideo, ts, data)
+}
+
+// WriteMetadata writes the data message with the FLV script tag data, such as onMetaData.
+// The data is wrapped with @setDataFrame to be stored by the server for players.
+func (s *Stream) WriteMetadata(ts int64, data []byte) error {
+	if len(unwrapDataFrame(data)) != len(data) {
+		return s.writeMedia(msgAmf0Meta, ts, data)
+	}
+	enc := amf.NewEncoder(0)
+	enc.WriteString("@setDataFrame")
+	copy(enc.Next(len(data)), data)
+	return s.writeMedia(msgAmf0Meta, ts, enc.Bytes())
+}
+
 func (s *Stream) Close() error {
 	s.Send("closeStream")
 	return s.Flush()
 }
+
+func (s *Stream) handleStatus(dec amf.Decoder) {
+	dec.Skip()
+	st, err := decodeStatus(dec)
+	if err != nil {
+		return
+	}
+	select {
+	case s.events <- st:
+	default:
+	}
+}
+
+func (s *Stream) handleControl(ev *UserControl) {
+	if s.controls == nil {
+		return
+	}
+	select {
+	case s.controls <- ev:
+	default:
+	}
+}
+
+// SendStatus sends onStatus event to the stream, such as NetStream.Play.Stop sent by the server to players.
+func (s *Stream) SendStatus(st *Status) error {
+	s.sendStatus(st)
+	return s.Flush()
+}
+
+func (s *Stream) sendStatus(st *Status) {
+	s.conn.writeCommand(s.id, "onStatus", 0, nil, st)
+}
+
+// handleMedia routes the media message received from the publisher to players
+// or delivers it to the reader of the client stream.
+func (s *Stream) handleMedia(ch *chunk) {
+	typ, data := ch.Type, ch.Data
+	if typ == msgAmf3Meta && len(data) > 0 {
+		typ, data = msgAmf0Meta, data[1:]
+	}
+	if typ == msgAmf0Meta {
+		data = unwrapDataFrame(data)
+	}
+	switch {
+	case s.publishing:
+		s.bc.write(typ, ch.Time, data)
+	case s.packets != nil:
+		if typ == msgVideo {
+			s.video = true
+		}
+		if s.skip && s.video && !isKeyFrame(typ, data) {
+			return
+		}
+		p := &Packet{Type: typ, Time: ch.Time, Data: make([]byte, len(data)), dropped: s.skip}
+		copy(p.Data, data)
+		select {
+		case s.packets <- p:
+			s.skip = false
+		default:
+			// Blocking here would stall acknowledgements, pings and results of the connection
+			s.skip = true
+		}
+	}
+}
+
+// isKeyFrame reports whether the message is a video key frame, the stream can be decoded from it.
+func isKeyFrame(typ uint8, data []byte) bool {
+	if typ != msgVideo {
+		return false
+	}
+	h, _, err := flv.ParseVideoHeader(data)
+	return err == nil && h.IsKeyFrame()
+}
+
+// writeMedia writes the media message waiting for acknowledgement of the peer
+// if the peer bandwidth is exceeded.
+func (s *Stream) writeMedia(typ uint8, ts int64, data []byte) error {
+	if !s.conn.w.wait() {
+		return s.conn.err
+	}
+	return s.writeMessage(typ, ts, data)
+}
+
+func (s *Stream) writeMessage(typ uint8, ts int64, data []byte) error {
+	id := chunkData
+	switch typ {
+	case msgAudio:
+		id = chunkAudio
+	case msgVideo:
+		id = chunkVideo
+	}
+	s.conn.w.WriteMessage(id, ts, typ, s.id, data)
+	return s.conn.w.Flush()
+}
+
+// unwrapDataFrame removes @setDataFrame from the data message sent by publisher.
+func unwrapDataFrame(b []byte) []byte {
+	const name = "@setDataFrame"
+	if n := 3 + len(name); len(b) > n && b[0] == 0x02 && int(be.Uint16(b[1:])) == len(name) && string(b[3:n]) == name {
+		return b[n:]
+	}
+	return b
+}
@@ -0,0 +1,47 @@
+# Relays one connection from the listen port to the server port and saves the handshake
+# to the output file as C0 C1, S0 S1 S2 and C2 (6146 bytes).
+# If s0 is given, S0 is replaced on the way to the client, the saved file holds the replaced byte.
+#
+#   python3 capture.py listen_port server_port output [s0]
+import socket, sys, threading, time
+
+lp, sp, out = int(sys.argv[1]), int(sys.argv[2]), sys.argv[3]
+s0 = int(sys.argv[4]) if len(sys.argv) > 4 else None
+
+def readn(s, n):
+    b = b''
+    while len(b) < n:
+        d = s.recv(n - len(b))
+        if not d:
+            raise EOFError
+        b += d
+    return b
+
+def pipe(a, b):
+    try:
+        while True:
+            d = a.recv(65536)
+            if not d:
+                break
+            b.sendall(d)
+    except OSError:
+        pass
+
+l = socket.socket()
+l.setsockopt(socket.SOL_SOCKET, socket.SO_REUSEADDR, 1)
+l.bind(('127.0.0.1', lp))
+l.listen(1)
+c, _ = l.accept()
+s = socket.create_connection(('127.0.0.1', sp))
+c01 = readn(c, 1537)
+s.sendall(c01)
+s012 = bytearray(readn(s, 3073))
+if s0 is not None:
+    s012[0] = s0
+c.sendall(s012)
+c2 = readn(c, 1536)
+s.sendall(c2)
+open(out, 'wb').write(c01 + s012 + c2)
+threading.Thread(target=pipe, args=(c, s), daemon=True).start()
+threading.Thread(target=pipe, args=(s, c), daemon=True).start()
+time.sleep(2)
@@ -0,0 +1,32 @@
+#!/bin/sh
+# Regenerates the handshake captures in the parent directory, see README.md.
+set -e
+cd "$(dirname "$0")"
+out=$(cd .. && pwd)
+tmp=$(mktemp -d)
+trap 'kill $www 2>/dev/null; rm -rf $tmp' EXIT
+
+cc -o $tmp/cli cli.c -l:librtmp.so.1
+cc -o $tmp/srv srv.c -l:librtmp.so.1
+
+# SWF verification makes the plain RTMP client sign C1, the SWF is an empty 28 bytes FWS file
+printf 'FWS\011\034\000\000\000' > $tmp/p.swf
+head -c 20 /dev/zero >> $tmp/p.swf
+(cd $tmp && exec python3 -m http.server 18080 --bind 127.0.0.1) > /dev/null 2>&1 &
+www=$!
+sleep 1
+
+# capture file url [s0]
+capture() {
+	$tmp/srv 19351 2> $tmp/srv.log &
+	sleep 0.3
+	python3 capture.py 19352 19351 $out/$1 $3 &
+	sleep 0.3
+	HOME=$tmp timeout 5 $tmp/cli "$2" 2> $tmp/cli.log || true
+	wait
+}
+
+capture handshake_librtmp_simple.bin "rtmp://127.0.0.1:19352/live/test"
+capture handshake_librtmp_fp9.bin "rtmp://127.0.0.1:19352/live/test swfUrl=http://127.0.0.1:18080/p.swf swfVfy=1"
+capture handshake_librtmp_rtmpe8.bin "rtmpe://127.0.0.1:19352/live/test"
+capture handshake_librtmp_rtmpe9.bin "rtmpe://127.0.0.1:19352/live/test" 9
@@ -0,0 +1,15 @@
+/* Client side of the handshake captures: connects to the RTMP URL with librtmp. */
+#include <stdio.h>
+#include "rtmp.h"
+
+int main(int argc, char **argv) {
+	RTMP_LogSetLevel(RTMP_LOGALL);
+	RTMP *r = RTMP_Alloc();
+	RTMP_Init(r);
+	if (!RTMP_SetupURL(r, argv[1])) {
+		fprintf(stderr, "bad url\n");
+		return 1;
+	}
+	fprintf(stderr, "CONNECT RESULT %d\n", RTMP_Connect(r, NULL));
+	return 0;
+}
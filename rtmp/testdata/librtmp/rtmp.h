@@ -0,0 +1,14 @@
+/* Declarations of librtmp 2.4 used by the capture programs, Debian ships librtmp.so.1 without headers. */
+typedef struct RTMP RTMP;
+
+RTMP *RTMP_Alloc(void);
+void RTMP_Init(RTMP *r);
+int RTMP_SetupURL(RTMP *r, char *url);
+int RTMP_Connect(RTMP *r, void *cp);
+int RTMP_Serve(RTMP *r);
+void RTMP_LogSetLevel(int lvl);
+
+#define RTMP_LOGALL 6
+
+/* Offset of m_sb.sb_socket in struct RTMP of librtmp 2.4+20151223.gitfa8646d on amd64. */
+#define RTMP_SOCKET(r) (*(int *)((char *)(r) + 0x110))
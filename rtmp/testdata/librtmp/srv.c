@@ -0,0 +1,29 @@
+/* Server side of the handshake captures: answers one connection on the port with RTMP_Serve of librtmp. */
+#include <stdio.h>
+#include <stdlib.h>
+#include <unistd.h>
+#include <netinet/in.h>
+#include <sys/socket.h>
+#include "rtmp.h"
+
+int main(int argc, char **argv) {
+	RTMP_LogSetLevel(RTMP_LOGALL);
+	int l = socket(AF_INET, SOCK_STREAM, 0), one = 1;
+	setsockopt(l, SOL_SOCKET, SO_REUSEADDR, &one, sizeof one);
+	struct sockaddr_in a = {0};
+	a.sin_family = AF_INET;
+	a.sin_port = htons(atoi(argv[1]));
+	a.sin_addr.s_addr = htonl(INADDR_LOOPBACK);
+	if (bind(l, (struct sockaddr *)&a, sizeof a) || listen(l, 1)) {
+		perror("listen");
+		return 1;
+	}
+	int fd = accept(l, NULL, NULL);
+	RTMP *r = RTMP_Alloc();
+	RTMP_Init(r);
+	RTMP_SOCKET(r) = fd;
+	fprintf(stderr, "SERVE RESULT %d\n", RTMP_Serve(r));
+	sleep(1);
+	close(fd);
+	return 0;
+}
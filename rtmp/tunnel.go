@@ -0,0 +1,515 @@
+package rtmp
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RTMPT tunnels the chunk stream over HTTP POST requests of the session:
+// /open/1 returns the session ID, /send/ID/SEQ sends data, /idle/ID/SEQ polls data and /close/ID/SEQ closes the session.
+// Responses start with the polling delay in units of tunnelPollUnit followed by data sent to the client.
+// Data sent to the client is buffered up to tunnelMaxBody, writers of the session wait for the client to poll it.
+// Data received from the client is buffered up to tunnelMaxBody as well, send requests wait for the session to read it.
+const (
+	tunnelContentType = "application/x-fcs"
+	tunnelPollUnit    = 10 * time.Millisecond
+	tunnelMaxDelay    = 32
+	tunnelTimeout     = time.Minute
+	tunnelMaxBody     = 1 << 20
+	tunnelMaxSessions = 1024
+)
+
+var (
+	errTunnelClosed   = errors.New("rtmp: tunnel closed")
+	errTunnelSessions = errors.New("rtmp: too many tunnel sessions")
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "rtmp: i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+type tunnelAddr string
+
+func (a tunnelAddr) Network() string { return "rtmpt" }
+func (a tunnelAddr) String() string  { return string(a) }
+
+// tunnelConn is the client side of RTMPT session.
+type tunnelConn struct {
+	client *http.Client
+	url    string
+	id     string
+	addr   tunnelAddr
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	reqmu sync.Mutex
+	seq   int
+
+	mu       sync.Mutex
+	buf      []byte
+	delay    time.Duration
+	polled   time.Time
+	rdl, wdl time.Time
+	closed   bool
+	notify   chan struct{}
+}
+
+// dialTunnel opens RTMPT session with the HTTP server at the address, using HTTPS if secure.
+func dialTunnel(ctx context.Context, client *http.Client, addr string, secure bool) (*tunnelConn, error) {
+	base := "http://" + addr
+	if secure {
+		base = "https://" + addr
+	}
+	req, err := http.NewRequest("POST", base+"/open/1", bytes.NewReader([]byte{0}))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", tunnelContentType)
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(res.Body, 256))
+	if err != nil {
+		return nil, err
+	}
+	id := strings.TrimSpace(string(b))
+	if res.StatusCode != http.StatusOK || id == "" {
+		return nil, fmt.Errorf("rtmp: tunnel open: %v", res.Status)
+	}
+	c := &tunnelConn{
+		client: client,
+		url:    base,
+		id:     id,
+		addr:   tunnelAddr(addr),
+		delay:  tunnelPollUnit,
+		notify: make(chan struct{}, 1),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c, nil
+}
+
+// post sends the command of the session and buffers data received in the response.
+func (c *tunnelConn) post(ctx context.Context, cmd string, body []byte) error {
+	c.reqmu.Lock()
+	defer c.reqmu.Unlock()
+	c.seq++
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/%s/%s/%d", c.url, cmd, c.id, c.seq), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", tunnelContentType)
+	res, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("rtmp: tunnel %s: %v", cmd, res.Status)
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if len(b) == 0 {
+		return fmt.Errorf("rtmp: tunnel %s: empty response", cmd)
+	}
+	c.mu.Lock()
+	c.buf = append(c.buf, b[1:]...)
+	c.delay = time.Duration(b[0]) * tunnelPollUnit
+	if c.delay < tunnelPollUnit {
+		c.delay = tunnelPollUnit
+	}
+	c.polled = time.Now()
+	c.mu.Unlock()
+	if len(b) > 1 {
+		c.wakeup()
+	}
+	return nil
+}
+
+func (c *tunnelConn) wakeup() {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+// Read returns buffered data or polls the server with idle requests at the delay requested by the server.
+func (c *tunnelConn) Read(b []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		if len(c.buf) > 0 {
+			n := copy(b, c.buf)
+			c.buf = c.buf[n:]
+			c.mu.Unlock()
+			return n, nil
+		}
+		if c.closed {
+			c.mu.Unlock()
+			return 0, io.EOF
+		}
+		now := time.Now()
+		if !c.rdl.IsZero() && !now.Before(c.rdl) {
+			c.mu.Unlock()
+			return 0, timeoutError{}
+		}
+		wait := c.polled.Add(c.delay).Sub(now)
+		if !c.rdl.IsZero() && c.rdl.Sub(now) < wait {
+			wait = c.rdl.Sub(now)
+		}
+		c.mu.Unlock()
+
+		if wait <= 0 {
+			if err := c.post(c.ctx, "idle", []byte{0}); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-c.notify:
+		case <-t.C:
+		}
+		t.Stop()
+	}
+}
+
+func (c *tunnelConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	closed, dl := c.closed, c.wdl
+	c.mu.Unlock()
+	if closed {
+		return 0, errTunnelClosed
+	}
+	if expired(dl) {
+		return 0, timeoutError{}
+	}
+	if err := c.post(c.ctx, "send", b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *tunnelConn) Close() error {
+	c.mu.Lock()
+	closed := c.closed
+	c.closed = true
+	c.mu.Unlock()
+	if closed {
+		return nil
+	}
+	c.cancel()
+	c.wakeup()
+	c.post(context.Background(), "close", []byte{0})
+	c.client.CloseIdleConnections()
+	return nil
+}
+
+func (c *tunnelConn) LocalAddr() net.Addr  { return c.addr }
+func (c *tunnelConn) RemoteAddr() net.Addr { return c.addr }
+
+func (c *tunnelConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.rdl, c.wdl = t, t
+	c.mu.Unlock()
+	c.wakeup()
+	return nil
+}
+
+func (c *tunnelConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.rdl = t
+	c.mu.Unlock()
+	c.wakeup()
+	return nil
+}
+
+func (c *tunnelConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.wdl = t
+	c.mu.Unlock()
+	return nil
+}
+
+// TunnelHandler is the http.Handler terminating RTMPT sessions.
+// Each session is served by the Server as an ordinary connection.
+type TunnelHandler struct {
+	Server *Server
+
+	// MaxSessions limits the number of concurrent sessions, 1024 if zero.
+	// Opening more sessions fails with 503 Service Unavailable.
+	MaxSessions int
+
+	mu       sync.Mutex
+	sessions map[string]*tunnelSession
+}
+
+func (h *TunnelHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, tunnelMaxBody))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", tunnelContentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	if path[0] == "open" {
+		s, err := h.open(r.RemoteAddr)
+		if err == errTunnelSessions {
+			http.Error(w, "too many sessions", http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		io.WriteString(w, s.id+"\n")
+		return
+	}
+	if len(path) != 3 {
+		http.NotFound(w, r)
+		return
+	}
+	if _, err := strconv.Atoi(path[2]); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	s := h.session(path[1])
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+	switch path[0] {
+	case "send":
+		s.receive(body)
+	case "idle":
+	case "close":
+		s.Close()
+		w.Write([]byte{0})
+		return
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	w.Write(s.poll())
+}
+
+func (h *TunnelHandler) open(remote string) (*tunnelSession, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	s := &tunnelSession{
+		handler: h,
+		id:      hex.EncodeToString(b),
+		remote:  tunnelAddr(remote),
+		delay:   1,
+	}
+	s.cond = sync.NewCond(&s.mu)
+	max := h.MaxSessions
+	if max <= 0 {
+		max = tunnelMaxSessions
+	}
+	h.mu.Lock()
+	if len(h.sessions) >= max {
+		h.mu.Unlock()
+		return nil, errTunnelSessions
+	}
+	if h.sessions == nil {
+		h.sessions = make(map[string]*tunnelSession)
+	}
+	h.sessions[s.id] = s
+	s.timer = time.AfterFunc(tunnelTimeout, func() { s.Close() })
+	h.mu.Unlock()
+	srv := h.Server
+	if srv == nil {
+		srv = &Server{}
+	}
+	go srv.serveConn(s)
+	return s, nil
+}
+
+func (h *TunnelHandler) session(id string) *tunnelSession {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.sessions[id]
+	if s != nil {
+		s.timer.Reset(tunnelTimeout)
+	}
+	return s
+}
+
+func (h *TunnelHandler) remove(s *tunnelSession) {
+	h.mu.Lock()
+	delete(h.sessions, s.id)
+	h.mu.Unlock()
+}
+
+// tunnelSession is the server side of RTMPT session, it is closed if the client does not poll it.
+type tunnelSession struct {
+	handler *TunnelHandler
+	id      string
+	remote  tunnelAddr
+	timer   *time.Timer
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	in, out  []byte
+	delay    uint8
+	rdl, wdl time.Time
+	rt, wt   *time.Timer
+	closed   bool
+}
+
+// receive buffers data sent by the client, waiting while the buffer is full.
+// The wait ends when the session is closed, at the latest by the session timeout.
+func (s *tunnelSession) receive(b []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.in) > 0 && len(s.in)+len(b) > tunnelMaxBody && !s.closed {
+		s.cond.Wait()
+	}
+	if !s.closed {
+		s.in = append(s.in, b...)
+	}
+	s.cond.Broadcast()
+}
+
+// poll returns the response with the polling delay and data sent to the client.
+// The delay grows while the session is idle.
+func (s *tunnelSession) poll() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.out) > 0 {
+		s.delay = 1
+	} else if s.delay < tunnelMaxDelay {
+		s.delay <<= 1
+	}
+	b := append([]byte{s.delay}, s.out...)
+	s.out = nil
+	s.cond.Broadcast()
+	return b
+}
+
+func (s *tunnelSession) Read(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.in) == 0 && !s.closed {
+		if expired(s.rdl) {
+			return 0, timeoutError{}
+		}
+		s.cond.Wait()
+	}
+	if len(s.in) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, s.in)
+	s.in = s.in[n:]
+	s.cond.Broadcast()
+	return n, nil
+}
+
+// Write buffers data until the client polls it, waiting while the buffer is full.
+func (s *tunnelSession) Write(b []byte) (n int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for n < len(b) {
+		if s.closed {
+			return n, errTunnelClosed
+		}
+		if expired(s.wdl) {
+			return n, timeoutError{}
+		}
+		if m := tunnelMaxBody - len(s.out); m > 0 {
+			if m > len(b)-n {
+				m = len(b) - n
+			}
+			s.out = append(s.out, b[n:n+m]...)
+			n += m
+			continue
+		}
+		s.cond.Wait()
+	}
+	return
+}
+
+func (s *tunnelSession) Close() error {
+	s.mu.Lock()
+	closed := s.closed
+	s.closed = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	if !closed {
+		s.timer.Stop()
+		s.handler.remove(s)
+	}
+	return nil
+}
+
+func (s *tunnelSession) LocalAddr() net.Addr  { return tunnelAddr("") }
+func (s *tunnelSession) RemoteAddr() net.Addr { return s.remote }
+
+func (s *tunnelSession) SetDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rdl, s.wdl = t, t
+	s.rt = s.wakeAt(s.rt, t)
+	s.wt = s.wakeAt(s.wt, t)
+	return nil
+}
+
+func (s *tunnelSession) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rdl = t
+	s.rt = s.wakeAt(s.rt, t)
+	return nil
+}
+
+func (s *tunnelSession) SetWriteDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.wdl = t
+	s.wt = s.wakeAt(s.wt, t)
+	return nil
+}
+
+// wakeAt replaces the timer waking up readers and writers waiting for the session at the deadline.
+func (s *tunnelSession) wakeAt(timer *time.Timer, t time.Time) *time.Timer {
+	if timer != nil {
+		timer.Stop()
+	}
+	s.cond.Broadcast()
+	if t.IsZero() {
+		return nil
+	}
+	return time.AfterFunc(time.Until(t), func() {
+		s.mu.Lock()
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	})
+}
+
+// expired reports whether the deadline is set and exceeded.
+func expired(t time.Time) bool {
+	return !t.IsZero() && !time.Now().Before(t)
+}
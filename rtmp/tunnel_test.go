@@ -0,0 +1,155 @@
+package rtmp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testTunnel(t *testing.T, uri string, d *Dialer) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	pc, err := d.DialContext(ctx, uri)
+	if err != nil {
+		t.Fatal("dial:", err)
+	}
+	defer pc.Close()
+	if _, ok := pc.Conn.(*tunnelConn); !ok {
+		t.Fatalf("connection %T is not tunneled", pc.Conn)
+	}
+	if _, err = pc.Ping(ctx); err != nil {
+		t.Fatal("ping:", err)
+	}
+	pub, err := pc.Publish(ctx, "test", "live")
+	if err != nil {
+		t.Fatal("publish:", err)
+	}
+	header := []byte{0x17, 0, 0, 0, 0, 1, 0x64, 0, 0x1f, 0xff}
+	pub.WriteVideo(0, header)
+
+	c, err := d.DialContext(ctx, uri)
+	if err != nil {
+		t.Fatal("dial:", err)
+	}
+	defer c.Close()
+	s, err := c.Play(ctx, "test")
+	if err != nil {
+		t.Fatal("play:", err)
+	}
+	s.ReadPacket() // |RtmpSampleAccess
+	if p, err := s.ReadPacket(); err != nil || !bytes.Equal(p.Data, header) {
+		t.Fatalf("read packet: %+v %v", p, err)
+	}
+}
+
+func TestTunnel(t *testing.T) {
+	h := &TunnelHandler{Server: &Server{}}
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+	testTunnel(t, "rtmpt://"+strings.TrimPrefix(ts.URL, "http://")+"/live", &Dialer{})
+
+	h.mu.Lock()
+	n := len(h.sessions)
+	h.mu.Unlock()
+	if n != 0 {
+		t.Fatalf("%d sessions are not closed", n)
+	}
+}
+
+func TestTunnelTLS(t *testing.T) {
+	ts := httptest.NewTLSServer(&TunnelHandler{Server: &Server{}})
+	defer ts.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+	testTunnel(t, "rtmpts://"+strings.TrimPrefix(ts.URL, "https://")+"/live", &Dialer{TLSConfig: &tls.Config{RootCAs: pool}})
+}
+
+func TestTunnelUnknownSession(t *testing.T) {
+	ts := httptest.NewServer(&TunnelHandler{})
+	defer ts.Close()
+	res, err := ts.Client().Post(ts.URL+"/idle/unknown/1", tunnelContentType, strings.NewReader("\x00"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 404 {
+		t.Fatalf("status %v", res.Status)
+	}
+}
+
+func TestTunnelMaxSessions(t *testing.T) {
+	ts := httptest.NewServer(&TunnelHandler{MaxSessions: 1})
+	defer ts.Close()
+	for i, status := range []int{200, 503} {
+		res, err := ts.Client().Post(ts.URL+"/open/1", tunnelContentType, strings.NewReader("\x00"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != status {
+			t.Fatalf("session %d: status %v", i, res.Status)
+		}
+	}
+}
+
+func TestTunnelSessionWrite(t *testing.T) {
+	h := &TunnelHandler{}
+	s, err := h.open("test")
+	if err != nil {
+		t.Fatal("open:", err)
+	}
+	defer s.Close()
+	// Writes wait for the client to poll the buffered data
+	s.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	n, err := s.Write(make([]byte, tunnelMaxBody+1000))
+	if e, ok := err.(net.Error); !ok || !e.Timeout() || n != tunnelMaxBody {
+		t.Fatalf("write: %d, %v", n, err)
+	}
+	if b := s.poll(); len(b) != tunnelMaxBody+1 {
+		t.Fatalf("poll: %d bytes", len(b))
+	}
+	s.SetWriteDeadline(time.Time{})
+	if n, err = s.Write(make([]byte, 1000)); err != nil || n != 1000 {
+		t.Fatalf("write: %d, %v", n, err)
+	}
+
+	s.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err = s.Read(make([]byte, 10)); err == nil {
+		t.Fatal("read: no timeout")
+	}
+}
+
+func TestTunnelSessionReceive(t *testing.T) {
+	s := &tunnelSession{}
+	s.cond = sync.NewCond(&s.mu)
+	s.receive(make([]byte, tunnelMaxBody))
+	// Send requests wait for the session to read the buffered data
+	done := make(chan struct{})
+	go func() {
+		s.receive(make([]byte, 1000))
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("receive: buffer is not limited")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if n, err := s.Read(make([]byte, 1000)); err != nil || n != 1000 {
+		t.Fatalf("read: %d, %v", n, err)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("receive: timeout")
+	}
+	if len(s.in) != tunnelMaxBody {
+		t.Fatalf("buffered %d bytes", len(s.in))
+	}
+}
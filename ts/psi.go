@@ -0,0 +1,76 @@
+package ts
+
+const (
+	pidPAT = uint16(0x0000)
+	pidPMT = uint16(0x1000)
+)
+
+const (
+	StreamTypeAAC  = uint8(0x0f)
+	StreamTypeH264 = uint8(0x1b)
+	StreamTypeHEVC = uint8(0x24)
+)
+
+// appendPAT appends the program association table section for a single program.
+func appendPAT(b []byte) []byte {
+	off := len(b)
+	b = append(b,
+		0x00,       // table_id
+		0xb0, 0x0d, // section_syntax_indicator, section_length
+		0x00, 0x01, // transport_stream_id
+		0xc1,       // version, current_next_indicator
+		0x00, 0x00, // section_number, last_section_number
+		0x00, 0x01, // program_number
+		0xe0|byte(pidPMT>>8), byte(pidPMT&0xff),
+	)
+	return appendCRC(b, b[off:])
+}
+
+// appendPMT appends the program map table section for the streams.
+func appendPMT(b []byte, pcr uint16, streams []*stream) []byte {
+	off := len(b)
+	n := 13 + 5*len(streams)
+	b = append(b,
+		0x02, // table_id
+		0xb0|byte(n>>8), byte(n),
+		0x00, 0x01, // program_number
+		0xc1,
+		0x00, 0x00,
+		0xe0|byte(pcr>>8), byte(pcr),
+		0xf0, 0x00, // program_info_length
+	)
+	for _, s := range streams {
+		b = append(b, s.typ, 0xe0|byte(s.pid>>8), byte(s.pid), 0xf0, 0x00)
+	}
+	return appendCRC(b, b[off:])
+}
+
+func appendCRC(b []byte, section []byte) []byte {
+	c := crc32(section)
+	return append(b, byte(c>>24), byte(c>>16), byte(c>>8), byte(c))
+}
+
+var crcTable [256]uint32
+
+func init() {
+	for i := range crcTable {
+		c := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ 0x04c11db7
+			} else {
+				c <<= 1
+			}
+		}
+		crcTable[i] = c
+	}
+}
+
+// crc32 calculates CRC-32/MPEG-2 of b.
+func crc32(b []byte) uint32 {
+	c := uint32(0xffffffff)
+	for _, it := range b {
+		c = c<<8 ^ crcTable[byte(c>>24)^it]
+	}
+	return c
+}
@@ -0,0 +1,125 @@
+package ts
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/pixelbender/go-rtmp/codec/aac"
+	"github.com/pixelbender/go-rtmp/codec/h264"
+	"github.com/pixelbender/go-rtmp/flv"
+)
+
+type testPES struct {
+	pid  uint16
+	pts  int64
+	dts  int64
+	data []byte
+}
+
+// demux checks packets and continuity counters and returns sections and PES packets.
+func demux(t *testing.T, b []byte) (sections map[uint16][]byte, pes []*testPES) {
+	if len(b)%PacketSize != 0 {
+		t.Fatalf("demux: size %d is not a multiple of packet size", len(b))
+	}
+	sections = make(map[uint16][]byte)
+	cc := make(map[uint16]uint8)
+	last := make(map[uint16]*testPES)
+	for ; len(b) > 0; b = b[PacketSize:] {
+		p := b[:PacketSize]
+		if p[0] != 0x47 {
+			t.Fatal("demux: no sync byte")
+		}
+		pid := uint16(p[1]&0x1f)<<8 | uint16(p[2])
+		if v, ok := cc[pid]; ok && p[3]&0x0f != (v+1)&0x0f {
+			t.Fatalf("demux: pid %x: discontinuity %d after %d", pid, p[3]&0x0f, v)
+		}
+		cc[pid] = p[3] & 0x0f
+		i := 4
+		if p[3]&0x20 != 0 {
+			i += 1 + int(p[4])
+		}
+		payload, start := p[i:], p[1]&0x40 != 0
+		if pid == pidPAT || pid == pidPMT {
+			n := 3 + (int(payload[2]&0x0f)<<8 | int(payload[3]))
+			s := payload[1 : 1+n]
+			if crc32(s) != 0 {
+				t.Fatalf("demux: pid %x: incorrect crc", pid)
+			}
+			sections[pid] = s
+			continue
+		}
+		if start {
+			if payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+				t.Fatal("demux: no PES start code")
+			}
+			it := &testPES{pid: pid, pts: readTimestamp(payload[9:])}
+			it.dts = it.pts
+			if payload[7]&0x40 != 0 {
+				it.dts = readTimestamp(payload[14:])
+			}
+			payload = payload[9+int(payload[8]):]
+			last[pid] = it
+			pes = append(pes, it)
+		}
+		last[pid].data = append(last[pid].data, payload...)
+	}
+	return
+}
+
+func readTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+}
+
+func TestWriter(t *testing.T) {
+	sps, _ := hex.DecodeString("6764002aacd940780227e5c044000003000400000300f03c60c658")
+	pps, _ := hex.DecodeString("68ebe3cb22c0")
+	vc, err := h264.NewDecoderConfig([][]byte{sps}, [][]byte{pps})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac := &aac.Config{ObjectType: aac.ObjectLC, SampleRate: 44100, Channels: 2, FrameLength: 1024}
+	frame := make([]byte, 400)
+	frame[0] = 0x65
+	tags := []struct {
+		typ  uint8
+		time int64
+		data []byte
+	}{
+		{flv.TypeVideo, 0, append([]byte{0x17, 0, 0, 0, 0}, vc.Bytes()...)},
+		{flv.TypeAudio, 0, aac.AppendSequenceHeader(nil, ac)},
+		{flv.TypeVideo, 0, append([]byte{0x17, 1, 0, 0, 40, 0, 0, 1, 0x90}, frame...)},
+		{flv.TypeAudio, 0, aac.AppendFrame(nil, []byte{0x21, 0x10})},
+		{flv.TypeVideo, 40, []byte{0x27, 1, 0, 0, 0, 0, 0, 0, 2, 0x41, 0x9a}},
+	}
+	b := &bytes.Buffer{}
+	w := NewWriter(b)
+	for _, it := range tags {
+		if err := w.WriteTag(&flv.Tag{Type: it.typ, Time: it.time}, it.data); err != nil {
+			t.Fatal("write tag:", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	sections, pes := demux(t, b.Bytes())
+	if pmt := sections[pidPMT]; pmt == nil || !bytes.Contains(pmt, []byte{StreamTypeH264, 0xe1, 0x00}) || !bytes.Contains(pmt, []byte{StreamTypeAAC, 0xe1, 0x01}) {
+		t.Fatalf("pmt: %x", pmt)
+	}
+	if len(pes) != 3 {
+		t.Fatalf("pes: %d packets", len(pes))
+	}
+	if v := pes[0]; v.pid != pidVideo || v.dts != startOffset || v.pts != startOffset+40*90 {
+		t.Fatalf("video: pts %d dts %d", v.pts, v.dts)
+	}
+	want := h264.AppendAnnexB(nil, []byte{0x09, 0xf0}, sps, pps, frame)
+	if !bytes.Equal(pes[0].data, want) {
+		t.Fatalf("video: %x", pes[0].data)
+	}
+	if a := pes[1]; a.pid != pidAudio || !bytes.Equal(a.data, []byte{0xff, 0xf1, 0x50, 0x80, 0x01, 0x3f, 0xfc, 0x21, 0x10}) {
+		t.Fatalf("audio: %x", a.data)
+	}
+	if v := pes[2]; v.pts != startOffset+40*90 || !bytes.Equal(v.data, h264.AppendAnnexB(nil, []byte{0x09, 0xf0}, []byte{0x41, 0x9a})) {
+		t.Fatalf("video: pts %d %x", v.pts, v.data)
+	}
+}
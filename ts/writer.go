@@ -0,0 +1,359 @@
+// Package ts implements muxing of FLV and RTMP media into MPEG transport stream.
+package ts
+
+import (
+	"bufio"
+	"errors"
+	"io"
+
+	"github.com/pixelbender/go-rtmp/codec/aac"
+	"github.com/pixelbender/go-rtmp/codec/h264"
+	"github.com/pixelbender/go-rtmp/codec/hevc"
+	"github.com/pixelbender/go-rtmp/flv"
+)
+
+// ErrCodec is returned when a sequence header describes an unsupported codec.
+var ErrCodec = errors.New("ts: unsupported codec")
+
+// PacketSize is the size of transport stream packet.
+const PacketSize = 188
+
+const (
+	pidVideo = uint16(0x100)
+	pidAudio = uint16(0x101)
+)
+
+const (
+	// startOffset shifts timestamps to keep PCR behind the decoding time.
+	startOffset = int64(126000)
+	pcrDelay    = int64(63000)
+)
+
+// DefaultTableInterval is the interval of PAT and PMT in milliseconds for streams without video.
+var DefaultTableInterval = int64(1000)
+
+type stream struct {
+	pid  uint16
+	typ  uint8
+	id   uint8
+	cc   uint8
+	avc  *h264.DecoderConfig
+	hevc *hevc.DecoderConfig
+	aac  *aac.Config
+}
+
+// Writer writes FLV tags as MPEG transport stream with a single program.
+// Streams are created from AVC, HEVC and AAC sequence headers received before the first frame.
+type Writer struct {
+	buf *bufio.Writer
+
+	video *stream
+	audio *stream
+
+	started bool
+	tables  int64
+	cc      [2]uint8 // PAT, PMT continuity counters
+	pes     []byte
+	psi     []byte
+	pkt     [PacketSize]byte
+
+	// TableInterval is the interval of PAT and PMT in milliseconds for streams without video.
+	TableInterval int64
+}
+
+// NewWriter returns a new writer of transport stream to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{buf: bufio.NewWriter(w), TableInterval: DefaultTableInterval}
+}
+
+// WriteTag writes FLV tag with the given data.
+func (w *Writer) WriteTag(tag *flv.Tag, data []byte) error {
+	switch tag.Type {
+	case flv.TypeVideo:
+		h, n, err := flv.ParseVideoHeader(data)
+		if err != nil {
+			return err
+		}
+		switch {
+		case h.IsSequenceHeader():
+			return w.setVideoConfig(h.FourCC, data[n:])
+		case h.IsCodedFrames() && w.video != nil:
+			return w.writeVideo(tag.Time, int64(h.CompositionTime), h.IsKeyFrame(), h.FourCC, data[n:])
+		}
+	case flv.TypeAudio:
+		h, n, err := flv.ParseAudioHeader(data)
+		if err != nil {
+			return err
+		}
+		switch {
+		case h.IsSequenceHeader():
+			return w.setAudioConfig(h.FourCC, data[n:])
+		case h.IsCodedFrames() && w.audio != nil && h.FourCC == flv.FourCCAAC:
+			return w.writeAudio(tag.Time, data[n:])
+		}
+	}
+	return nil
+}
+
+func (w *Writer) setVideoConfig(fourcc uint32, b []byte) (err error) {
+	s := w.video
+	if s == nil {
+		if w.started {
+			return nil
+		}
+		s = &stream{pid: pidVideo, id: 0xe0}
+	}
+	switch fourcc {
+	case flv.FourCCAVC:
+		if s.typ != 0 && s.typ != StreamTypeH264 {
+			return nil
+		}
+		if s.avc, err = h264.ParseDecoderConfig(b); err != nil {
+			return
+		}
+		s.typ = StreamTypeH264
+	case flv.FourCCHEVC:
+		if s.typ != 0 && s.typ != StreamTypeHEVC {
+			return nil
+		}
+		if s.hevc, err = hevc.ParseDecoderConfig(b); err != nil {
+			return
+		}
+		s.typ = StreamTypeHEVC
+	default:
+		return ErrCodec
+	}
+	w.video = s
+	return nil
+}
+
+func (w *Writer) setAudioConfig(fourcc uint32, b []byte) (err error) {
+	if fourcc != flv.FourCCAAC {
+		return ErrCodec
+	}
+	s := w.audio
+	if s == nil {
+		if w.started {
+			return nil
+		}
+		s = &stream{pid: pidAudio, typ: StreamTypeAAC, id: 0xc0}
+	}
+	if s.aac, err = aac.ParseConfig(b); err != nil {
+		return
+	}
+	w.audio = s
+	return nil
+}
+
+func (w *Writer) writeVideo(ms, cto int64, key bool, fourcc uint32, data []byte) error {
+	s := w.video
+	var (
+		nals [][]byte
+		err  error
+	)
+	switch {
+	case s.typ == StreamTypeH264 && fourcc == flv.FourCCAVC:
+		if nals, err = h264.SplitAVCC(data, s.avc.LengthSize); err != nil {
+			return err
+		}
+		b := w.pes[:0]
+		if len(nals) == 0 || h264.NALType(nals[0]) != h264.NALAUD {
+			b = h264.AppendAnnexB(b, []byte{0x09, 0xf0})
+		}
+		if key && !hasNAL(nals, h264.NALType, h264.NALSPS) {
+			b = h264.AppendAnnexB(b, s.avc.SPS...)
+			b = h264.AppendAnnexB(b, s.avc.PPS...)
+		}
+		w.pes = h264.AppendAnnexB(b, nals...)
+	case s.typ == StreamTypeHEVC && fourcc == flv.FourCCHEVC:
+		if nals, err = h264.SplitAVCC(data, s.hevc.LengthSize); err != nil {
+			return err
+		}
+		b := w.pes[:0]
+		if len(nals) == 0 || hevc.NALType(nals[0]) != hevc.NALAUD {
+			b = h264.AppendAnnexB(b, []byte{0x46, 0x01, 0x50})
+		}
+		if key && !hasNAL(nals, hevc.NALType, hevc.NALSPS) {
+			b = h264.AppendAnnexB(b, s.hevc.VPS()...)
+			b = h264.AppendAnnexB(b, s.hevc.SPS()...)
+			b = h264.AppendAnnexB(b, s.hevc.PPS()...)
+		}
+		w.pes = h264.AppendAnnexB(b, nals...)
+	default:
+		return nil
+	}
+	if key || !w.started {
+		if err = w.writeTables(ms); err != nil {
+			return err
+		}
+	}
+	dts := ms*90 + startOffset
+	return w.writePES(s, dts+cto*90, dts, key)
+}
+
+func (w *Writer) writeAudio(ms int64, frame []byte) (err error) {
+	s := w.audio
+	if w.pes, err = aac.AppendADTS(w.pes[:0], s.aac, frame); err != nil {
+		return
+	}
+	if !w.started || w.video == nil && ms-w.tables >= w.TableInterval {
+		if err = w.writeTables(ms); err != nil {
+			return
+		}
+	}
+	ts := ms*90 + startOffset
+	return w.writePES(s, ts, ts, true)
+}
+
+func (w *Writer) pcrStream() *stream {
+	if w.video != nil {
+		return w.video
+	}
+	return w.audio
+}
+
+func (w *Writer) writeTables(ms int64) error {
+	w.started, w.tables = true, ms
+	var streams []*stream
+	if w.video != nil {
+		streams = append(streams, w.video)
+	}
+	if w.audio != nil {
+		streams = append(streams, w.audio)
+	}
+	w.psi = appendPAT(w.psi[:0])
+	if err := w.writeSection(pidPAT, &w.cc[0], w.psi); err != nil {
+		return err
+	}
+	w.psi = appendPMT(w.psi[:0], w.pcrStream().pid, streams)
+	return w.writeSection(pidPMT, &w.cc[1], w.psi)
+}
+
+func (w *Writer) writeSection(pid uint16, cc *uint8, section []byte) error {
+	p := w.pkt[:]
+	p[0] = 0x47
+	p[1] = 0x40 | byte(pid>>8)&0x1f
+	p[2] = byte(pid)
+	p[3] = 0x10 | *cc
+	*cc = (*cc + 1) & 0x0f
+	p[4] = 0 // pointer_field
+	n := copy(p[5:], section)
+	for i := 5 + n; i < PacketSize; i++ {
+		p[i] = 0xff
+	}
+	_, err := w.buf.Write(p)
+	return err
+}
+
+// writePES writes the payload in w.pes as PES packet split into transport stream packets.
+func (w *Writer) writePES(s *stream, pts, dts int64, key bool) error {
+	var h [19]byte
+	h[2], h[3] = 1, s.id
+	h[6] = 0x80
+	n := 14
+	if pts != dts {
+		h[7], h[8] = 0xc0, 10
+		putTimestamp(h[9:], 0x3, pts)
+		putTimestamp(h[14:], 0x1, dts)
+		n = 19
+	} else {
+		h[7], h[8] = 0x80, 5
+		putTimestamp(h[9:], 0x2, pts)
+	}
+	if size := n - 6 + len(w.pes); s.typ == StreamTypeAAC && size <= 0xffff {
+		h[4], h[5] = byte(size>>8), byte(size)
+	}
+	data := w.pes
+	pcr := s == w.pcrStream()
+	for first := true; len(data) > 0 || first; first = false {
+		p := w.pkt[:]
+		p[0] = 0x47
+		p[1] = byte(s.pid>>8) & 0x1f
+		p[2] = byte(s.pid)
+		p[3] = 0x10 | s.cc
+		s.cc = (s.cc + 1) & 0x0f
+		flags, af := byte(0), 0
+		if first {
+			p[1] |= 0x40
+			if key {
+				flags |= 0x40 // random_access_indicator
+			}
+			if pcr {
+				flags |= 0x10
+			}
+		}
+		if flags != 0 {
+			af = 2
+			if flags&0x10 != 0 {
+				af += 6
+			}
+		}
+		size := len(data)
+		if first {
+			size += n
+		}
+		if m := PacketSize - 4 - af; size < m {
+			af += m - size
+		}
+		if af > 0 {
+			p[3] |= 0x20
+			p[4] = byte(af - 1)
+			i := 5
+			if af > 1 {
+				p[5] = flags
+				i++
+				if flags&0x10 != 0 {
+					putPCR(p[6:], dts-pcrDelay)
+					i += 6
+				}
+			}
+			for ; i < 4+af; i++ {
+				p[i] = 0xff
+			}
+		}
+		i := 4 + af
+		if first {
+			i += copy(p[i:], h[:n])
+		}
+		data = data[copy(p[i:], data):]
+		if _, err := w.buf.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// putTimestamp writes 33-bit PTS or DTS with the given prefix.
+func putTimestamp(b []byte, prefix byte, v int64) {
+	v &= 0x1ffffffff
+	b[0] = prefix<<4 | byte(v>>29)&0x0e | 1
+	b[1] = byte(v >> 22)
+	b[2] = byte(v>>14)&0xfe | 1
+	b[3] = byte(v >> 7)
+	b[4] = byte(v<<1) | 1
+}
+
+// putPCR writes the program clock reference with 90kHz base.
+func putPCR(b []byte, v int64) {
+	v &= 0x1ffffffff
+	b[0] = byte(v >> 25)
+	b[1] = byte(v >> 17)
+	b[2] = byte(v >> 9)
+	b[3] = byte(v >> 1)
+	b[4] = byte(v<<7) | 0x7e
+	b[5] = 0
+}
+
+func hasNAL(nals [][]byte, typeOf func([]byte) uint8, typ uint8) bool {
+	for _, it := range nals {
+		if typeOf(it) == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// Flush writes any buffered data to the underlying writer.
+func (w *Writer) Flush() error {
+	return w.buf.Flush()
+}